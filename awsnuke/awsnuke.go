@@ -0,0 +1,99 @@
+package awsnuke
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Return the resource types supported by the installed aws-nuke
+func ResourceTypes() ([]string, error) {
+    awsNukeResourceTypesTemp, err := exec.Command("bash", "-c", "aws-nuke resource-types").Output()
+    if err != nil {
+        return nil, fmt.Errorf("unable to list aws-nuke resource types, %w", err)
+    }
+
+    var resourceTypes []string
+    for _, resourceType := range strings.Split(string(awsNukeResourceTypesTemp), "\n") {
+        resourceType = strings.TrimSpace(resourceType)
+        if len(resourceType) != 0 {
+            resourceTypes = append(resourceTypes, resourceType)
+        }
+    }
+    return resourceTypes, nil
+}
+
+// The first aws-nuke release which applies filters listed under the __global__ key to every resource type. The key is defined by libnuke
+// (filter.Global), which aws-nuke uses from v3.0.0 of github.com/ekristen/aws-nuke. The v2 releases of github.com/rebuy-de/aws-nuke
+// document no such key, and would ignore the filters under it, so they get the per-type filter blocks
+var globalFiltersVersion = [3]int{3, 0, 0}
+
+// Return the version of the installed aws-nuke, e.g. v2.25.0
+func Version() (string, error) {
+    output, err := exec.Command("bash", "-c", "aws-nuke version").Output()
+    if err != nil {
+        // v3 prints its version with the --version flag
+        output, err = exec.Command("bash", "-c", "aws-nuke --version").Output()
+        if err != nil {
+            return "", fmt.Errorf("unable to get the aws-nuke version, %w", err)
+        }
+    }
+    return ParseVersionOutput(string(output))
+}
+
+// Find the version in the output of aws-nuke version or aws-nuke --version. v2 prints a table such as "version:     v2.25.0",
+// v3 a line such as "aws-nuke version 3.29.0"
+func ParseVersionOutput(output string) (string, error) {
+    for _, line := range strings.Split(output, "\n") {
+        fields := strings.Fields(line)
+        var version string
+        switch {
+        case len(fields) == 2 && fields[0] == "version:":
+            version = fields[1]
+        case len(fields) >= 3 && fields[0] == "aws-nuke" && fields[1] == "version":
+            version = fields[2]
+        default:
+            continue
+        }
+        if _, ok := parseVersion(version); !ok {
+            return "", fmt.Errorf("unable to parse the aws-nuke version %q", version)
+        }
+        return version, nil
+    }
+    return "", fmt.Errorf("unable to find the version in the output of aws-nuke version: %q", strings.TrimSpace(output))
+}
+
+// Whether the aws-nuke of the given version supports filters under the __global__ key. Unparseable versions are assumed not to
+func SupportsGlobalFilters(version string) bool {
+    parsed, ok := parseVersion(version)
+    if !ok {
+        return false
+    }
+    for i := range parsed {
+        if parsed[i] != globalFiltersVersion[i] {
+            return parsed[i] > globalFiltersVersion[i]
+        }
+    }
+    return true
+}
+
+// Parse a version such as v2.25.0, 2.25.0-rc1 or v1.0.39.gc2f318f into its major, minor and patch numbers
+func parseVersion(version string) ([3]int, bool) {
+    var parsed [3]int
+    version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+    version = strings.SplitN(version, "-", 2)[0]
+    // Anything after the patch number, such as the commit of a development build, is ignored
+    parts := strings.SplitN(version, ".", 4)
+    if len(parts) < 3 {
+        return parsed, false
+    }
+    for i, part := range parts[:3] {
+        number, err := strconv.Atoi(part)
+        if err != nil {
+            return parsed, false
+        }
+        parsed[i] = number
+    }
+    return parsed, true
+}
@@ -0,0 +1,86 @@
+package awsnuke
+
+import (
+	"testing"
+)
+
+func TestParseVersionOutput(t *testing.T) {
+    tests := []struct {
+        name    string
+        output  string
+        version string
+        invalid bool
+    }{
+        {
+            name:    "v2 version command",
+            output:  "version:     v2.25.0\nbuild date:  2023-08-22T07:05:54Z\nscm hash:    a5bd0d3d4c8d2a6d9b1c7f3e2b4a9c8d7e6f5a4b\nenvironment: release\n",
+            version: "v2.25.0",
+        },
+        {
+            name:    "v2 development build",
+            output:  "version:     v2.25.0-3-g1a2b3c4\nbuild date:  2023-09-01T10:00:00Z\nscm hash:    1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b\nenvironment: local\n",
+            version: "v2.25.0-3-g1a2b3c4",
+        },
+        {
+            name:    "v3 --version flag",
+            output:  "aws-nuke version 3.29.0\n",
+            version: "3.29.0",
+        },
+        {
+            name:    "v1 banner",
+            output:  "aws-nuke version v1.0.39.gc2f318f - Fri Jul 28 16:26:41 CEST 2017 - c2f318f37b7d2dec0e646da3d4d05ab5296d5bce\n",
+            version: "v1.0.39.gc2f318f",
+        },
+        {
+            name:    "unset build version",
+            output:  "version:     unknown\nbuild date:  unknown\n",
+            invalid: true,
+        },
+        {
+            name:    "no version",
+            output:  "Error: unknown command \"version\" for \"aws-nuke\"\n",
+            invalid: true,
+        },
+        {name: "empty", invalid: true},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            version, err := ParseVersionOutput(test.output)
+            if test.invalid {
+                if err == nil {
+                    t.Errorf("ParseVersionOutput = %q, want an error", version)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if version != test.version {
+                t.Errorf("ParseVersionOutput = %q, want %q", version, test.version)
+            }
+        })
+    }
+}
+
+func TestSupportsGlobalFilters(t *testing.T) {
+    tests := []struct {
+        version  string
+        supports bool
+    }{
+        {"v1.0.39.gc2f318f", false},
+        {"v2.17.0", false},
+        {"v2.25.0", false},
+        {"v3.0.0-beta.1", true},
+        {"3.0.0", true},
+        {"v3.29.0", true},
+        {"v10.0.0", true},
+        {"unknown", false},
+        {"", false},
+    }
+    for _, test := range tests {
+        if supports := SupportsGlobalFilters(test.version); supports != test.supports {
+            t.Errorf("SupportsGlobalFilters(%q) = %v, want %v", test.version, supports, test.supports)
+        }
+    }
+}
@@ -0,0 +1,43 @@
+package awsnuke
+
+import (
+	"awsnukeshield/helpers"
+)
+
+// aws-nuke resource types which expose no tag properties, so are never matched by tag filters.
+// Mostly resources which only exist as part of another, such as policy attachments and bucket objects
+var untaggedTypes = []string{
+    "CloudWatchEventsTarget",
+    "CloudWatchLogsResourcePolicy",
+    "EC2DefaultSecurityGroupRule",
+    "IAMGroup",
+    "IAMGroupPolicy",
+    "IAMGroupPolicyAttachment",
+    "IAMInstanceProfileRole",
+    "IAMLoginProfile",
+    "IAMRolePolicy",
+    "IAMRolePolicyAttachment",
+    "IAMSigningCertificate",
+    "IAMUserAccessKey",
+    "IAMUserGroupAttachment",
+    "IAMUserPolicy",
+    "IAMUserPolicyAttachment",
+    "IAMUserSSHPublicKey",
+    "KMSAlias",
+    "LambdaEventSourceMapping",
+    "Route53ResourceRecordSet",
+    "S3MultipartUpload",
+    "S3Object",
+    "SNSSubscription",
+}
+
+// Return those of resourceTypes which are known not to expose tag properties
+func UntaggedTypes(resourceTypes []string) []string {
+    var untagged []string
+    for _, untaggedType := range untaggedTypes {
+        if helpers.FindItemExact(resourceTypes, untaggedType) != -1 {
+            untagged = append(untagged, untaggedType)
+        }
+    }
+    return untagged
+}
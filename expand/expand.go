@@ -0,0 +1,139 @@
+package expand
+
+import (
+	"awsnukeshield/nukeconfig"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// A declarative companion rule: when a resource is preserved, also preserve the resources of Type whose Property matches Value.
+// Value is a text/template, executed with the preserved resource as TemplateData. It defaults to "{{.ID}}"
+type Rule struct {
+    Type       string `yaml:"type"`
+    Property   string `yaml:"property,omitempty"`
+    FilterType string `yaml:"filter-type,omitempty"`
+    Value      string `yaml:"value,omitempty"`
+}
+
+// The data available to value templates
+type TemplateData struct {
+    // The CFN physical ID of the preserved resource
+    ID      string
+    // The account being nuked
+    Account string
+}
+
+// A filter derived from a preserved resource
+type Expansion struct {
+    SourceType string
+    SourceID   string
+    Type       string
+    Filter     nukeconfig.Filter
+}
+
+// Functions available to value templates, e.g. {{last "/" .ID}} for the name at the end of an ARN
+var templateFuncs = template.FuncMap{
+    "last": func(sep string, s string) string {
+        parts := strings.Split(s, sep)
+        return parts[len(parts)-1]
+    },
+    "first": func(sep string, s string) string {
+        return strings.Split(s, sep)[0]
+    },
+}
+
+type compiledRule struct {
+    Rule
+    value *template.Template
+}
+
+// Derives companion filters from preserved resources
+type Engine struct {
+    rules map[string][]compiledRule
+}
+
+// Create an engine from companion rules keyed by the aws-nuke type of the preserved resource, compiling every value template
+func NewEngine(rules map[string][]Rule) (*Engine, error) {
+    engine := &Engine{rules: make(map[string][]compiledRule)}
+    for sourceType, sourceRules := range rules {
+        for _, rule := range sourceRules {
+            value, err := rule.compile()
+            if err != nil {
+                return nil, fmt.Errorf("companion %s of %s: %w", rule.Type, sourceType, err)
+            }
+            engine.rules[sourceType] = append(engine.rules[sourceType], compiledRule{Rule: rule, value: value})
+        }
+    }
+    return engine, nil
+}
+
+func (r Rule) Validate() error {
+    _, err := r.compile()
+    return err
+}
+
+func (r Rule) compile() (*template.Template, error) {
+    if r.Type == "" {
+        return nil, fmt.Errorf("type is required")
+    }
+    switch r.FilterType {
+    case "", nukeconfig.FilterTypeExact, nukeconfig.FilterTypeGlob, nukeconfig.FilterTypeRegex, nukeconfig.FilterTypeContains:
+    default:
+        return nil, fmt.Errorf("unknown filter type %q", r.FilterType)
+    }
+
+    valueTemplate := r.Value
+    if valueTemplate == "" {
+        valueTemplate = "{{.ID}}"
+    }
+    value, err := template.New(r.Type).Funcs(templateFuncs).Option("missingkey=error").Parse(valueTemplate)
+    if err != nil {
+        return nil, err
+    }
+    // Unknown fields are only reported when the template is executed, so try it on a sample resource
+    if err := value.Execute(io.Discard, TemplateData{ID: "id", Account: "000000000000"}); err != nil {
+        return nil, err
+    }
+    return value, nil
+}
+
+// Describe the rule for display
+func (r Rule) String() string {
+    property := r.Property
+    if property == "" {
+        property = "(resource ID)"
+    }
+    filterType := r.FilterType
+    if filterType == "" {
+        filterType = nukeconfig.FilterTypeExact
+    }
+    value := r.Value
+    if value == "" {
+        value = "{{.ID}}"
+    }
+    return fmt.Sprintf("%s %s %s %s", r.Type, property, filterType, value)
+}
+
+// Return the filters preserving the companions of the preserved resource of sourceType, in rule order
+func (e *Engine) Expand(sourceType string, data TemplateData) ([]Expansion, error) {
+    var expansions []Expansion
+    for _, rule := range e.rules[sourceType] {
+        var value bytes.Buffer
+        if err := rule.value.Execute(&value, data); err != nil {
+            return nil, fmt.Errorf("companion %s of %s %s: %w", rule.Type, sourceType, data.ID, err)
+        }
+        if value.Len() == 0 {
+            return nil, fmt.Errorf("companion %s of %s %s: the value is empty", rule.Type, sourceType, data.ID)
+        }
+        expansions = append(expansions, Expansion{
+            SourceType: sourceType,
+            SourceID:   data.ID,
+            Type:       rule.Type,
+            Filter:     nukeconfig.Filter{Property: rule.Property, Type: rule.FilterType, Value: value.String()},
+        })
+    }
+    return expansions, nil
+}
@@ -0,0 +1,139 @@
+package expand
+
+import (
+	"awsnukeshield/nukeconfig"
+	"reflect"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+    const roleArn = "arn:aws:iam::123456789012:role/service/deployer"
+    tests := []struct {
+        name    string
+        rule    Rule
+        data    TemplateData
+        filter  nukeconfig.Filter
+        invalid bool
+    }{
+        {name: "default value", rule: Rule{Type: "IAMRolePolicy"}, data: TemplateData{ID: "deployer"}, filter: nukeconfig.Filter{Value: "deployer"}},
+        {name: "property and filter type", rule: Rule{Type: "IAMRolePolicy", Property: "role:RoleName", FilterType: nukeconfig.FilterTypeGlob, Value: "{{.ID}}*"}, data: TemplateData{ID: "deployer"}, filter: nukeconfig.Filter{Property: "role:RoleName", Type: "glob", Value: "deployer*"}},
+        {name: "last", rule: Rule{Type: "IAMRolePolicyAttachment", Value: `{{last "/" .ID}}`}, data: TemplateData{ID: roleArn}, filter: nukeconfig.Filter{Value: "deployer"}},
+        {name: "first", rule: Rule{Type: "IAMRolePolicyAttachment", Value: `{{first "|" .ID}}`}, data: TemplateData{ID: "deployer|policy"}, filter: nukeconfig.Filter{Value: "deployer"}},
+        {name: "last without the separator", rule: Rule{Type: "IAMRolePolicyAttachment", Value: `{{last "/" .ID}}`}, data: TemplateData{ID: "deployer"}, filter: nukeconfig.Filter{Value: "deployer"}},
+        {name: "account", rule: Rule{Type: "S3Bucket", Value: "{{.ID}}-logs-{{.Account}}"}, data: TemplateData{ID: "site", Account: "123456789012"}, filter: nukeconfig.Filter{Value: "site-logs-123456789012"}},
+        {name: "literal value", rule: Rule{Type: "IAMRole", Value: "OrganizationAccountAccessRole"}, data: TemplateData{ID: "deployer"}, filter: nukeconfig.Filter{Value: "OrganizationAccountAccessRole"}},
+
+        {name: "empty value", rule: Rule{Type: "IAMRolePolicy", Value: `{{last "/" .ID}}`}, data: TemplateData{ID: "role/"}, invalid: true},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            engine, err := NewEngine(map[string][]Rule{"IAMRole": {test.rule}})
+            if err != nil {
+                t.Fatal(err)
+            }
+            expansions, err := engine.Expand("IAMRole", test.data)
+            if test.invalid {
+                if err == nil {
+                    t.Errorf("Expand(%+v) = %+v, want an error", test.data, expansions)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("Expand(%+v): %v", test.data, err)
+            }
+            want := []Expansion{{SourceType: "IAMRole", SourceID: test.data.ID, Type: test.rule.Type, Filter: test.filter}}
+            if !reflect.DeepEqual(expansions, want) {
+                t.Errorf("Expand(%+v) = %+v, want %+v", test.data, expansions, want)
+            }
+        })
+    }
+}
+
+func TestExpandRuleOrder(t *testing.T) {
+    engine, err := NewEngine(map[string][]Rule{
+        "IAMRole": {
+            {Type: "IAMRolePolicy", Property: "role:RoleName"},
+            {Type: "IAMRolePolicyAttachment", Property: "RoleName"},
+            {Type: "IAMInstanceProfileRole", FilterType: nukeconfig.FilterTypeContains, Value: " -> {{.ID}}"},
+        },
+        "S3Bucket": {{Type: "S3Object", Property: "Bucket"}},
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        sourceType string
+        types      []string
+    }{
+        {sourceType: "IAMRole", types: []string{"IAMRolePolicy", "IAMRolePolicyAttachment", "IAMInstanceProfileRole"}},
+        {sourceType: "S3Bucket", types: []string{"S3Object"}},
+        {sourceType: "EC2Instance", types: nil},
+    }
+
+    for _, test := range tests {
+        t.Run(test.sourceType, func(t *testing.T) {
+            expansions, err := engine.Expand(test.sourceType, TemplateData{ID: "deployer", Account: "123456789012"})
+            if err != nil {
+                t.Fatal(err)
+            }
+            var types []string
+            for _, expansion := range expansions {
+                types = append(types, expansion.Type)
+            }
+            if !reflect.DeepEqual(types, test.types) {
+                t.Errorf("Expand(%s) gave types %v, want %v", test.sourceType, types, test.types)
+            }
+        })
+    }
+}
+
+func TestRuleValidate(t *testing.T) {
+    tests := []struct {
+        name    string
+        rule    Rule
+        invalid bool
+    }{
+        {name: "type only", rule: Rule{Type: "IAMRolePolicy"}},
+        {name: "every filter type", rule: Rule{Type: "IAMRolePolicy", FilterType: nukeconfig.FilterTypeRegex, Value: "^{{.ID}}$"}},
+        {name: "functions", rule: Rule{Type: "IAMRolePolicy", Value: `{{last "/" .ID}}-{{first ":" .Account}}`}},
+
+        {name: "no type", rule: Rule{Value: "{{.ID}}"}, invalid: true},
+        {name: "unknown filter type", rule: Rule{Type: "IAMRolePolicy", FilterType: "prefix"}, invalid: true},
+        {name: "unparseable template", rule: Rule{Type: "IAMRolePolicy", Value: "{{.ID"}, invalid: true},
+        {name: "unknown field", rule: Rule{Type: "IAMRolePolicy", Value: "{{.Name}}"}, invalid: true},
+        {name: "unknown function", rule: Rule{Type: "IAMRolePolicy", Value: `{{upper .ID}}`}, invalid: true},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            err := test.rule.Validate()
+            if test.invalid && err == nil {
+                t.Errorf("Validate(%+v) succeeded, want an error", test.rule)
+            }
+            if !test.invalid && err != nil {
+                t.Errorf("Validate(%+v): %v", test.rule, err)
+            }
+            if _, engineErr := NewEngine(map[string][]Rule{"IAMRole": {test.rule}}); (engineErr != nil) != test.invalid {
+                t.Errorf("NewEngine with %+v: %v, want an error %v", test.rule, engineErr, test.invalid)
+            }
+        })
+    }
+}
+
+func TestRuleString(t *testing.T) {
+    tests := []struct {
+        rule Rule
+        want string
+    }{
+        {rule: Rule{Type: "IAMRolePolicy"}, want: "IAMRolePolicy (resource ID) exact {{.ID}}"},
+        {rule: Rule{Type: "IAMRolePolicy", Property: "role:RoleName", FilterType: "glob", Value: "{{.ID}}*"}, want: "IAMRolePolicy role:RoleName glob {{.ID}}*"},
+    }
+
+    for _, test := range tests {
+        if described := test.rule.String(); described != test.want {
+            t.Errorf("String() = %q, want %q", described, test.want)
+        }
+    }
+}
@@ -1,6 +1,9 @@
 package helpers
 
-import "strings"
+import (
+	"sort"
+	"strings"
+)
 
 /* Define a custom flag type that stores a list of values as a single argument */
 type StringListFlag []string
@@ -30,6 +33,29 @@ func RemoveDuplicates[T comparable](slice []T) []T {
     return slice
 }
 
+// Return the keys of the map, sorted, so that it can be iterated in the same order on every run
+func SortedKeys[V any](m map[string]V) []string {
+    keys := make([]string, 0, len(m))
+    for key := range m {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// Return a sorted copy of the slice, with duplicate items removed. The slice provided is left untouched
+func SortedUnique(slice []string) []string {
+    sorted := append([]string{}, slice...)
+    sort.Strings(sorted)
+    unique := sorted[:0]
+    for i, item := range sorted {
+        if i == 0 || item != sorted[i-1] {
+            unique = append(unique, item)
+        }
+    }
+    return unique
+}
+
 // Return the index of the first item in the given slice which exactly matches the given target string
 func FindItemExact(arr []string, target string) int {
     for i, item := range arr {
@@ -0,0 +1,199 @@
+package interlock
+
+import (
+	"awsnukeshield/nukeconfig"
+	"awsnukeshield/nukeoutput"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A resource which Shield preserves, and the filter it added for it
+type Expectation struct {
+    AwsNukeType string
+    // The CFN type of the preserved resource. Empty for companions, which have no CFN type
+    CFNType     string
+    // The resource as Shield knows it, e.g. the CFN physical ID, for the report. Events are matched through Filter only
+    Identity    string
+    Filter      nukeconfig.Filter
+    // Why the resource is preserved, for the report
+    Source      string
+}
+
+// What the dry run reported for an expectation
+const (
+    OutcomeFiltered    = "filtered"
+    OutcomeWouldRemove = "would remove"
+    // The resource may no longer exist, or the filter may not match it, so whether it is preserved is unknown
+    OutcomeNotSeen     = "not seen"
+    // The type is excluded or not targeted in the generated config, so aws-nuke cannot remove the resource either
+    OutcomeNotScanned  = "not scanned"
+    // A companion matched no resource, e.g. a role without inline policies, which is expected
+    OutcomeNoneFound   = "none found"
+    // aws-nuke printed a message for the resource which Shield does not recognise
+    OutcomeUnknown     = "unknown state"
+)
+
+type Result struct {
+    Expectation Expectation
+    Outcome     string
+    // The events about the resource
+    Events      []nukeoutput.Event
+}
+
+// The cross-check of the dry run against what Shield preserves
+type Report struct {
+    Results       []Result
+    // Resources carrying a preserved tag which aws-nuke would remove
+    TagMisses     []nukeoutput.Event
+    // Resources, preserved or not, whose state Shield could not tell from the message aws-nuke printed
+    UnknownStates []nukeoutput.Event
+    Filtered      int
+    WouldRemove   int
+    NotSeen       int
+    NotScanned    int
+    NoneFound     int
+    Unknown       int
+}
+
+// Whether no preserved resource would be removed, the state of every resource is known and, unless allowNotSeen,
+// every preserved resource was seen filtered
+func (r Report) Clean(allowNotSeen bool) bool {
+    return r.WouldRemove == 0 && len(r.TagMisses) == 0 && len(r.UnknownStates) == 0 && (allowNotSeen || r.NotSeen == 0)
+}
+
+// Check every expectation, and every resource carrying one of the tag filters, against the events of an aws-nuke dry run.
+// scans tells whether aws-nuke scans an aws-nuke type at all, as resources of types it does not scan are never seen
+func Check(events []nukeoutput.Event, expectations []Expectation, tagFilters []nukeconfig.Filter, scans func(awsNukeType string) bool) Report {
+    eventsByType := make(map[string][]nukeoutput.Event)
+    for _, event := range events {
+        eventsByType[event.ResourceType] = append(eventsByType[event.ResourceType], event)
+    }
+
+    var report Report
+    for _, expectation := range expectations {
+        result := Result{Expectation: expectation, Outcome: OutcomeNotSeen}
+        for _, event := range eventsByType[expectation.AwsNukeType] {
+            if !concerns(expectation, event) {
+                continue
+            }
+            result.Events = append(result.Events, event)
+            if event.WouldRemove() {
+                result.Outcome = OutcomeWouldRemove
+            } else if event.Unknown() && result.Outcome != OutcomeWouldRemove {
+                result.Outcome = OutcomeUnknown
+            } else if event.Filtered() && result.Outcome == OutcomeNotSeen {
+                result.Outcome = OutcomeFiltered
+            }
+        }
+
+        if result.Outcome == OutcomeNotSeen {
+            if !scans(expectation.AwsNukeType) {
+                result.Outcome = OutcomeNotScanned
+            } else if expectation.CFNType == "" {
+                result.Outcome = OutcomeNoneFound
+            }
+        }
+
+        switch result.Outcome {
+        case OutcomeFiltered:
+            report.Filtered++
+        case OutcomeWouldRemove:
+            report.WouldRemove++
+        case OutcomeNotScanned:
+            report.NotScanned++
+        case OutcomeNoneFound:
+            report.NoneFound++
+        case OutcomeUnknown:
+            report.Unknown++
+        default:
+            report.NotSeen++
+        }
+        report.Results = append(report.Results, result)
+    }
+
+    for _, event := range events {
+        if event.Kind == nukeoutput.KindResource && event.Unknown() {
+            report.UnknownStates = append(report.UnknownStates, event)
+        }
+        if !event.WouldRemove() {
+            continue
+        }
+        for _, filter := range tagFilters {
+            value, tagged := event.Properties[filter.Property]
+            if !tagged {
+                continue
+            }
+            if matched, _ := filter.Match(value); matched {
+                report.TagMisses = append(report.TagMisses, event)
+                break
+            }
+        }
+    }
+
+    sort.SliceStable(report.Results, func(i, j int) bool {
+        if report.Results[i].Expectation.AwsNukeType != report.Results[j].Expectation.AwsNukeType {
+            return report.Results[i].Expectation.AwsNukeType < report.Results[j].Expectation.AwsNukeType
+        }
+        return report.Results[i].Expectation.Identity < report.Results[j].Expectation.Identity
+    })
+    return report
+}
+
+// Whether the event is about the expected resource: the filter Shield emitted for it matches the event, on the filter's property or
+// else on the ID, exactly as aws-nuke compares it. Nothing else counts, as a resource of the same name elsewhere would hide a miss
+func concerns(expectation Expectation, event nukeoutput.Event) bool {
+    value := event.ID
+    if expectation.Filter.Property != "" {
+        var present bool
+        if value, present = event.Properties[expectation.Filter.Property]; !present {
+            return false
+        }
+    }
+    matched, _ := expectation.Filter.Match(value)
+    return matched && value != ""
+}
+
+// Print the coverage report: every preserved resource which would be removed or was not seen, and the totals.
+// Resources not seen are failures unless allowNotSeen
+func (r Report) Print(allowNotSeen bool) {
+    fmt.Println("\n\nSAFETY INTERLOCK:")
+    fmt.Println()
+    fmt.Println("The preserved resources, as reported by the aws-nuke dry run:")
+    for _, result := range r.Results {
+        if result.Outcome == OutcomeFiltered || result.Outcome == OutcomeNoneFound {
+            continue
+        }
+        marker := "-"
+        if result.Outcome == OutcomeWouldRemove || result.Outcome == OutcomeUnknown || (result.Outcome == OutcomeNotSeen && !allowNotSeen) {
+            marker = "!!!"
+        }
+        fmt.Printf("%s %s %s (%s): %s\n", marker, result.Expectation.AwsNukeType, result.Expectation.Identity, result.Expectation.Source, strings.ToUpper(result.Outcome))
+    }
+    for _, event := range r.TagMisses {
+        fmt.Printf("!!! %s %s %s carries a preserved tag: WOULD REMOVE\n", event.Region, event.ResourceType, event.ID)
+    }
+    for _, event := range r.UnknownStates {
+        fmt.Printf("!!! %s %s %s: UNKNOWN STATE %q\n", event.Region, event.ResourceType, event.ID, event.Message)
+    }
+
+    total := len(r.Results)
+    fmt.Printf("\n%d preserved resources checked: %d filtered, %d would be removed, %d in an unknown state, %d not seen in the dry run, %d of types aws-nuke does not scan, %d companions matching nothing\n",
+        total, r.Filtered, r.WouldRemove, r.Unknown, r.NotSeen, r.NotScanned, r.NoneFound)
+    if total != 0 {
+        fmt.Printf("Coverage: %.1f%% of the preserved resources were confirmed filtered\n", 100*float64(r.Filtered)/float64(total))
+    }
+    if r.NotSeen != 0 {
+        if allowNotSeen {
+            fmt.Println("-allow-not-seen was given, so the resources not seen are accepted, although whether they are preserved is unknown")
+        } else {
+            fmt.Println("Resources not seen may no longer exist, or the filter emitted for them may not match them. Check them, then pass -allow-not-seen to accept them")
+        }
+    }
+    if len(r.TagMisses) != 0 {
+        fmt.Printf("%d resources carrying a preserved tag would be removed\n", len(r.TagMisses))
+    }
+    if len(r.UnknownStates) != 0 {
+        fmt.Printf("aws-nuke printed a message Shield does not recognise for %d resources, so whether it would remove them is unknown\n", len(r.UnknownStates))
+    }
+}
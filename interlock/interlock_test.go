@@ -0,0 +1,247 @@
+package interlock
+
+import (
+	"awsnukeshield/nukeconfig"
+	"awsnukeshield/nukeoutput"
+	"reflect"
+	"testing"
+)
+
+func resource(resourceType string, id string, state nukeoutput.State, properties map[string]string) nukeoutput.Event {
+    return nukeoutput.Event{Kind: nukeoutput.KindResource, Region: "eu-west-1", ResourceType: resourceType, ID: id, Properties: properties, State: state}
+}
+
+// The generated config excludes Route53HostedZone
+func scans(awsNukeType string) bool {
+    return awsNukeType != "Route53HostedZone"
+}
+
+func TestCheck(t *testing.T) {
+    events := []nukeoutput.Event{
+        resource("ECSCluster", "arn:aws:ecs:eu-west-1:123456789012:cluster/app-cluster", nukeoutput.StateFiltered, nil),
+        resource("ECSCluster", "arn:aws:ecs:eu-west-1:123456789012:cluster/other-cluster", nukeoutput.StateWouldRemove, nil),
+        resource("S3Bucket", "s3://app-logs", nukeoutput.StateWouldRemove, map[string]string{"Name": "app-logs"}),
+        resource("SNSTopic", "arn:aws:sns:eu-west-1:123456789012:alerts", nukeoutput.StateFiltered, map[string]string{"TopicARN": "arn:aws:sns:eu-west-1:123456789012:alerts"}),
+        resource("SNSTopic", "arn:aws:sns:eu-west-1:123456789012:alerts-dev", nukeoutput.StateWouldRemove, map[string]string{"TopicARN": "arn:aws:sns:eu-west-1:123456789012:alerts-dev"}),
+        resource("SQSQueue", "https://sqs.eu-west-1.amazonaws.com/123456789012/jobs", nukeoutput.StateFiltered, map[string]string{"QueueURL": "https://sqs.eu-west-1.amazonaws.com/123456789012/jobs"}),
+        // A resource aws-nuke filters itself is filtered too
+        resource("IAMRole", "app-Role", nukeoutput.StateFiltered, map[string]string{"Name": "app-Role"}),
+        resource("IAMRolePolicyAttachment", "app-Role -> AdministratorAccess", nukeoutput.StateFiltered, map[string]string{"RoleName": "app-Role"}),
+        resource("EC2Instance", "i-0a1b2c3d", nukeoutput.StateFiltered, map[string]string{"tag:Team": "platform"}),
+        resource("EC2Instance", "i-0e5f6a7b", nukeoutput.StateWouldRemove, map[string]string{"tag:Team": "Platform"}),
+        resource("EC2Instance", "i-09c8d7e6", nukeoutput.StateWouldRemove, map[string]string{"tag:Team": "data"}),
+        resource("EC2Volume", "vol-0a1b2c3d", nukeoutput.StateWouldRemove, map[string]string{"tag:Env": "dev"}),
+        resource("EC2Volume", "vol-0e5f6a7b", nukeoutput.StateWouldRemove, map[string]string{"tag:Env": "prod"}),
+    }
+    expectations := []Expectation{
+        {AwsNukeType: "ECSCluster", CFNType: "AWS::ECS::Cluster", Identity: "app-cluster", Filter: nukeconfig.Filter{Type: nukeconfig.FilterTypeGlob, Value: "arn:aws*:ecs:*:*:cluster/app-cluster"}},
+        {AwsNukeType: "S3Bucket", CFNType: "AWS::S3::Bucket", Identity: "app-logs", Filter: nukeconfig.Filter{Property: "Name", Value: "app-logs"}},
+        {AwsNukeType: "SNSTopic", CFNType: "AWS::SNS::Topic", Identity: "arn:aws:sns:eu-west-1:123456789012:alerts", Filter: nukeconfig.Filter{Property: "TopicARN", Type: nukeconfig.FilterTypeRegex, Value: `^arn:aws:sns:[^:]+:\d+:alerts$`}},
+        {AwsNukeType: "SQSQueue", CFNType: "AWS::SQS::Queue", Identity: "https://sqs.eu-west-1.amazonaws.com/123456789012/jobs", Filter: nukeconfig.Filter{Property: "QueueURL", Type: nukeconfig.FilterTypeContains, Value: "/123456789012/jobs"}},
+        // Preserved, but gone before the dry run
+        {AwsNukeType: "DynamoDBTable", CFNType: "AWS::DynamoDB::Table", Identity: "app-table", Filter: nukeconfig.Filter{Value: "app-table"}},
+        // A companion, which has no CFN type
+        {AwsNukeType: "IAMRolePolicyAttachment", Identity: "app-Role", Filter: nukeconfig.Filter{Property: "RoleName", Value: "app-Role"}, Source: "companion of IAMRole app-Role"},
+        // A companion matching nothing, as the role has no inline policies
+        {AwsNukeType: "IAMRolePolicy", Identity: "app-Role", Filter: nukeconfig.Filter{Property: "role:RoleName", Value: "app-Role"}, Source: "companion of IAMRole app-Role"},
+        // Of a type the config excludes
+        {AwsNukeType: "Route53HostedZone", CFNType: "AWS::Route53::HostedZone", Identity: "Z0123456789", Filter: nukeconfig.Filter{Type: nukeconfig.FilterTypeContains, Value: "Z0123456789"}},
+    }
+    tagFilters := []nukeconfig.Filter{
+        {Property: "tag:Team", Type: nukeconfig.FilterTypeRegex, Value: "(?i)^platform$"},
+        // Everything not tagged Env=prod is preserved
+        {Property: "tag:Env", Value: "prod", Invert: "true"},
+    }
+
+    report := Check(events, expectations, tagFilters, scans)
+
+    outcomes := make(map[string]string)
+    for _, result := range report.Results {
+        outcomes[result.Expectation.AwsNukeType] = result.Outcome
+        for _, event := range result.Events {
+            if event.ResourceType != result.Expectation.AwsNukeType {
+                t.Errorf("%s has an event of type %s", result.Expectation.AwsNukeType, event.ResourceType)
+            }
+        }
+    }
+    wantOutcomes := map[string]string{
+        "ECSCluster":              OutcomeFiltered,
+        "S3Bucket":                OutcomeWouldRemove,
+        "SNSTopic":                OutcomeFiltered,
+        "SQSQueue":                OutcomeFiltered,
+        "DynamoDBTable":           OutcomeNotSeen,
+        "IAMRolePolicyAttachment": OutcomeFiltered,
+        "IAMRolePolicy":           OutcomeNoneFound,
+        "Route53HostedZone":       OutcomeNotScanned,
+    }
+    if !reflect.DeepEqual(outcomes, wantOutcomes) {
+        t.Errorf("outcomes = %v, want %v", outcomes, wantOutcomes)
+    }
+    if report.Filtered != 4 || report.WouldRemove != 1 || report.NotSeen != 1 || report.NotScanned != 1 || report.NoneFound != 1 {
+        t.Errorf("counts = %d filtered, %d would remove, %d not seen, %d not scanned, %d none found, want 4, 1, 1, 1, 1",
+            report.Filtered, report.WouldRemove, report.NotSeen, report.NotScanned, report.NoneFound)
+    }
+
+    var tagMisses []string
+    for _, event := range report.TagMisses {
+        tagMisses = append(tagMisses, event.ID)
+    }
+    wantTagMisses := []string{"i-0e5f6a7b", "vol-0a1b2c3d"}
+    if !reflect.DeepEqual(tagMisses, wantTagMisses) {
+        t.Errorf("tag misses = %v, want %v", tagMisses, wantTagMisses)
+    }
+    if report.Clean(true) {
+        t.Error("Clean(true) = true for a preserved resource which would be removed")
+    }
+}
+
+func TestCheckClean(t *testing.T) {
+    events := []nukeoutput.Event{
+        resource("S3Bucket", "s3://app-logs", nukeoutput.StateFiltered, map[string]string{"Name": "app-logs"}),
+        resource("S3Bucket", "s3://scratch", nukeoutput.StateWouldRemove, map[string]string{"Name": "scratch", "tag:Team": "data"}),
+    }
+    expectations := []Expectation{
+        {AwsNukeType: "S3Bucket", CFNType: "AWS::S3::Bucket", Identity: "app-logs", Filter: nukeconfig.Filter{Property: "Name", Value: "app-logs"}},
+    }
+    tagFilters := []nukeconfig.Filter{{Property: "tag:Team", Value: "platform"}}
+
+    report := Check(events, expectations, tagFilters, scans)
+    if !report.Clean(false) {
+        t.Errorf("Clean(false) = false, want true: %+v", report)
+    }
+    if len(report.Results) != 1 || len(report.Results[0].Events) != 1 || report.Results[0].Events[0].ID != "s3://app-logs" {
+        t.Errorf("the expectation concerns %+v, want only s3://app-logs", report.Results)
+    }
+}
+
+func TestCheckNotSeen(t *testing.T) {
+    events := []nukeoutput.Event{
+        resource("S3Bucket", "s3://scratch", nukeoutput.StateWouldRemove, map[string]string{"Name": "scratch"}),
+    }
+    expectations := []Expectation{
+        {AwsNukeType: "S3Bucket", CFNType: "AWS::S3::Bucket", Identity: "app-logs", Filter: nukeconfig.Filter{Property: "Name", Value: "app-logs"}},
+    }
+
+    report := Check(events, expectations, nil, scans)
+    if report.NotSeen != 1 {
+        t.Fatalf("NotSeen = %d, want 1", report.NotSeen)
+    }
+    if report.Clean(false) {
+        t.Error("Clean(false) = true for a preserved resource which was not seen")
+    }
+    if !report.Clean(true) {
+        t.Error("Clean(true) = false, although resources not seen are allowed")
+    }
+}
+
+func TestCheckUnknownState(t *testing.T) {
+    events := []nukeoutput.Event{
+        resource("S3Bucket", "s3://app-logs", nukeoutput.StateUnknown, map[string]string{"Name": "app-logs"}),
+        resource("S3Bucket", "s3://scratch", nukeoutput.StateUnknown, map[string]string{"Name": "scratch"}),
+    }
+    expectations := []Expectation{
+        {AwsNukeType: "S3Bucket", CFNType: "AWS::S3::Bucket", Identity: "app-logs", Filter: nukeconfig.Filter{Property: "Name", Value: "app-logs"}},
+    }
+
+    report := Check(events, expectations, nil, scans)
+    if report.Unknown != 1 || report.Results[0].Outcome != OutcomeUnknown {
+        t.Errorf("results = %+v, want app-logs in an unknown state", report.Results)
+    }
+    // Resources which are not preserved count too, as Shield cannot tell whether aws-nuke would remove them
+    if len(report.UnknownStates) != 2 {
+        t.Errorf("unknown states = %v, want both buckets", report.UnknownStates)
+    }
+    if report.Clean(true) {
+        t.Error("Clean(true) = true for resources in an unknown state")
+    }
+}
+
+func TestConcerns(t *testing.T) {
+    tests := []struct {
+        name        string
+        expectation Expectation
+        event       nukeoutput.Event
+        concerns    bool
+    }{
+        {
+            name:        "glob on the ID",
+            expectation: Expectation{Identity: "app-cluster", Filter: nukeconfig.Filter{Type: nukeconfig.FilterTypeGlob, Value: "arn:aws*:ecs:*:*:cluster/app-*"}},
+            event:       resource("ECSCluster", "arn:aws-us-gov:ecs:us-gov-west-1:123456789012:cluster/app-other", nukeoutput.StateFiltered, nil),
+            concerns:    true,
+        },
+        {
+            name:        "glob ? matches a single character",
+            expectation: Expectation{Filter: nukeconfig.Filter{Type: nukeconfig.FilterTypeGlob, Value: "app-?"}},
+            event:       resource("ELBv2", "app-12", nukeoutput.StateFiltered, nil),
+            concerns:    false,
+        },
+        {
+            name:        "regex is not anchored",
+            expectation: Expectation{Filter: nukeconfig.Filter{Type: nukeconfig.FilterTypeRegex, Value: "alerts"}},
+            event:       resource("SNSTopic", "arn:aws:sns:eu-west-1:123456789012:alerts-dev", nukeoutput.StateFiltered, nil),
+            concerns:    true,
+        },
+        {
+            name:        "contains on a property",
+            expectation: Expectation{Filter: nukeconfig.Filter{Property: "QueueURL", Type: nukeconfig.FilterTypeContains, Value: "/jobs"}},
+            event:       resource("SQSQueue", "jobs", nukeoutput.StateFiltered, map[string]string{"QueueURL": "https://sqs.eu-west-1.amazonaws.com/123456789012/jobs"}),
+            concerns:    true,
+        },
+        {
+            name:        "filter property missing from the event",
+            expectation: Expectation{Filter: nukeconfig.Filter{Property: "Name", Type: nukeconfig.FilterTypeContains, Value: ""}},
+            event:       resource("S3Bucket", "s3://app-logs", nukeoutput.StateFiltered, nil),
+            concerns:    false,
+        },
+        {
+            name:        "inverted filter matches other values",
+            expectation: Expectation{Filter: nukeconfig.Filter{Property: "tag:Env", Value: "prod", Invert: "true"}},
+            event:       resource("EC2Volume", "vol-0a1b2c3d", nukeoutput.StateFiltered, map[string]string{"tag:Env": "dev"}),
+            concerns:    true,
+        },
+        {
+            name:        "inverted filter does not match its value",
+            expectation: Expectation{Filter: nukeconfig.Filter{Property: "tag:Env", Value: "prod", Invert: "true"}},
+            event:       resource("EC2Volume", "vol-0e5f6a7b", nukeoutput.StateFiltered, map[string]string{"tag:Env": "prod"}),
+            concerns:    false,
+        },
+        {
+            name:        "ID ending with the identity, although the filter does not match",
+            expectation: Expectation{Identity: "app-cluster", Filter: nukeconfig.Filter{Value: "app-cluster"}},
+            event:       resource("ECSCluster", "arn:aws:ecs:eu-west-1:123456789012:cluster/app-cluster", nukeoutput.StateWouldRemove, nil),
+            concerns:    false,
+        },
+        {
+            name:        "same name under another ARN path",
+            expectation: Expectation{Identity: "web", Filter: nukeconfig.Filter{Value: "arn:aws:ecs:eu-west-1:123456789012:service/prod/web"}},
+            event:       resource("ECSService", "arn:aws:ecs:eu-west-1:123456789012:service/dev/web", nukeoutput.StateFiltered, nil),
+            concerns:    false,
+        },
+        {
+            name:        "another property holding the identity",
+            expectation: Expectation{Identity: "app-logs", Filter: nukeconfig.Filter{Value: "app-logs"}},
+            event:       resource("S3Bucket", "s3://app-logs", nukeoutput.StateFiltered, map[string]string{"Name": "app-logs"}),
+            concerns:    false,
+        },
+        {
+            name:        "filter on the property holding the identity",
+            expectation: Expectation{Identity: "app-logs", Filter: nukeconfig.Filter{Property: "Name", Value: "app-logs"}},
+            event:       resource("S3Bucket", "s3://app-logs", nukeoutput.StateWouldRemove, map[string]string{"Name": "app-logs"}),
+            concerns:    true,
+        },
+        {
+            name:        "identity as a prefix only",
+            expectation: Expectation{Identity: "app", Filter: nukeconfig.Filter{Value: "app"}},
+            event:       resource("ELBv2", "app-alb", nukeoutput.StateWouldRemove, nil),
+            concerns:    false,
+        },
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            if got := concerns(test.expectation, test.event); got != test.concerns {
+                t.Errorf("concerns(%+v, %+v) = %v, want %v", test.expectation, test.event, got, test.concerns)
+            }
+        })
+    }
+}
@@ -1,223 +1,226 @@
 package main
 
 import (
+	"awsnukeshield/awsnuke"
+	"awsnukeshield/expand"
 	"awsnukeshield/helpers"
+	"awsnukeshield/interlock"
+	"awsnukeshield/mapping"
+	"awsnukeshield/nukeconfig"
+	"awsnukeshield/nukeoutput"
+	"awsnukeshield/plan"
+	"awsnukeshield/report"
 	"awsnukeshield/resources"
-	"bufio"
+	"awsnukeshield/rules"
+	"awsnukeshield/translate"
+	_ "embed"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
-// AWS regions to search for resources to preserve. By default, use all EU and US regions which are enabled by default in AWS accounts
-var aws_regions = []string {"eu-west-1", "eu-west-2", "eu-west-3", "eu-north-1", "eu-central-1", "us-east-1", "us-east-2", "us-west-1", "us-west-2"}
+// The default rules file, used when shield-rules.yml is not present alongside Shield
+//go:embed shield-rules.yml
+var defaultRulesYAML []byte
 
-// Overwrite the contents of the configFile with the contents of lines
-func writeLinesToFile(logger *zap.Logger, configFile string, lines []string) {
-    fmt.Println("\n\nWriting to the config file...")
+// Add the static filters of the rules to the config document, under the filters of the given account
+// These are for resources which will not be captured by provided stack regexes or tags
+func addRulesFilters(logger *zap.Logger, doc *nukeconfig.Document, account string, shieldRules *rules.Rules) error {
+    for _, key := range helpers.SortedKeys(shieldRules.Filters) {
+        filters := shieldRules.Filters[key]
+        // Add the resources for preservation to the correct resource section of the file, under filters
+        if err := doc.AddFilters(account, key, filters...); err != nil {
+            return err
+        }
+        logger.Debug(fmt.Sprintf("Added rules filters for %s: %v", key, filters))
+    }
+
+    return nil
+}
 
-    // Open the file for writing (truncating the existing content)
-    file, err := os.Create(configFile)
+
+// Add the tag filters to the config document, once under __global__ if the installed aws-nuke supports it, else for every resource type.
+// Warns about the resource types which tag filters cannot protect. Returns where the filters were added
+func preserveTags(logger *zap.Logger, doc *nukeconfig.Document, account string, awsNukeResourceTypes []string, tagFilters []nukeconfig.Filter) (string, error) {
+    if len(tagFilters) == 0 {
+        return "", nil
+    }
+
+    fmt.Println("\n\nTAGS TO PRESERVE:")
+    fmt.Println()
+    for _, filter := range tagFilters {
+        fmt.Printf("- %s\n", filter.Describe())
+    }
+    fmt.Println()
+    global := false
+    addedTo := "every resource type"
+    version, err := awsnuke.Version()
     if err != nil {
-        panic(err)
+        fmt.Printf("WARNING: %v. The tag filters are added to every resource type\n", err)
+    } else if awsnuke.SupportsGlobalFilters(version) {
+        global = true
+        addedTo = nukeconfig.GlobalFilterKey
+        fmt.Printf("aws-nuke %s supports global filters, so the tag filters are added once under %s\n", version, nukeconfig.GlobalFilterKey)
+    } else {
+        fmt.Printf("aws-nuke %s does not support global filters, so the tag filters are added to every resource type\n", version)
     }
-    defer file.Close()
 
-    // Write the provided lines back to the file
-    writer := bufio.NewWriter(file)
-    for _, line := range lines {
-        _, err := writer.WriteString(line + "\n")
-        if err != nil {
-            panic(err)
+    if err := resources.GenerateTagsConfigSection(logger, doc, account, awsNukeResourceTypes, tagFilters, global); err != nil {
+        return "", err
+    }
+
+    if untagged := awsnuke.UntaggedTypes(awsNukeResourceTypes); len(untagged) != 0 {
+        fmt.Println("\nWARNING: the following resource types expose no tags, so are NOT protected by the tag filters:")
+        for _, resourceType := range untagged {
+            fmt.Printf("- %s\n", resourceType)
         }
+        fmt.Println("Use companions in the rules, or -preserve-resource-types, to protect them")
     }
-    writer.Flush()
+
+    return addedTo, nil
 }
 
-// Write the additional resource filters to the lines array, in preparation for being written back to the config file
-// To add more, add them into this function, copying one of the existing filters as an example
-// This should only be used for writing resources for preservation which will not be captured by provided stack regexes or tags
-// If unwanted, simply don't call the function from main
-func addAdditionalFilters(logger *zap.Logger, lines []string) []string {
-    additionalFilters := make(map[string][]string)
-    additionalFilters["IAMRole"] = append(additionalFilters["IAMRole"], `        - "AWSCloudFormationStackSetExecutionRole"`)
-    additionalFilters["IAMRole"] = append(additionalFilters["IAMRole"], `        - property: Name
-          type: contains
-          value: "stacksets-exec"`)
-    additionalFilters["IAMRolePolicy"] = append(additionalFilters["IAMRolePolicy"], `        - property: role:RoleName
-          value: "AWSCloudFormationStackSetExecutionRole"`)
-    additionalFilters["IAMRolePolicyAttachment"] = append(additionalFilters["IAMRolePolicyAttachment"], `        - property: RoleName
-          type: contains
-          value: "stacksets-exec"`)
-    additionalFilters["IAMSAMLProvider"] = append(additionalFilters["IAMSAMLProvider"], `        - type: contains
-          value: "DO_NOT_DELETE"`)
-    additionalFilters["SNSSubscription"] = append(additionalFilters["SNSSubscription"], `        - type: contains
-          value: "aws-controltower"`)
-    additionalFilters["CloudWatchEventsRule"] = append(additionalFilters["CloudWatchEventsRule"], `        - type: contains
-          value: "aws-controltower"`)
-    additionalFilters["CloudWatchEventsTarget"] = append(additionalFilters["CloudWatchEventsTarget"], `        - type: contains
-          value: "aws-controltower"`)
-
-    for key, filters := range additionalFilters {
-        // Add the resources for preservation to the correct resource section of the file, under filters
-        index_of_resource_block := helpers.FindItem(lines, fmt.Sprintf("%s:", key))
-        indexToInsert := index_of_resource_block + 1
-        lines = append(lines[:indexToInsert], append(filters, lines[indexToInsert:]...)...)
-        logger.Debug(fmt.Sprintf("New contents of the config file: %v", lines))
+// With -all-tags, add the tag filters to the aws-nuke types whose resources the Resource Groups Tagging API cannot find in the scanned
+// regions, so that their resources carrying any of the tags are preserved rather than none. Returns the types they were added to
+func preserveTagsOfUncoveredTypes(logger *zap.Logger, doc *nukeconfig.Document, account string, mapper *mapping.Mapper, awsNukeResourceTypes []string, tagFilters []nukeconfig.Filter, regions []string) ([]string, error) {
+    globalScanned := helpers.FindItemExact(regions, "us-east-1") != -1
+    covered := make(map[string]bool)
+    for _, cfnType := range resources.ARNTypes(globalScanned) {
+        if awsNukeType, _, found := mapper.Lookup(cfnType); found && awsNukeType != "" {
+            covered[awsNukeType] = true
+        }
+    }
+    // Tag filters cannot protect the types which expose no tags either way
+    untagged := awsnuke.UntaggedTypes(awsNukeResourceTypes)
+    var uncovered []string
+    for _, awsNukeType := range awsNukeResourceTypes {
+        if !covered[awsNukeType] && helpers.FindItemExact(untagged, awsNukeType) == -1 {
+            uncovered = append(uncovered, awsNukeType)
+        }
+    }
+    if len(uncovered) == 0 {
+        return nil, nil
     }
 
-    return lines
+    fmt.Printf("\n\nWARNING: the Resource Groups Tagging API cannot find resources of %d aws-nuke types in the scanned regions, so -all-tags cannot resolve them.\n", len(uncovered))
+    if !globalScanned {
+        fmt.Println("Global resources, such as IAM roles, are among them, as us-east-1 is not scanned.")
+    }
+    fmt.Println("Their resources are preserved when carrying ANY of the tags instead:")
+    fmt.Println(strings.Join(uncovered, ", "))
+    if err := resources.GenerateTagsConfigSection(logger, doc, account, uncovered, tagFilters, false); err != nil {
+        return nil, err
+    }
+    return uncovered, nil
 }
 
-
-// Write the contents of filter_contents to the lines array, in preparation for being written back to the config file
-// The content is inserted at the beginning of the filter section of the file, with resource IDs as child elements of resource type keys
-func generateResourceConfigSection(logger *zap.Logger, lines []string, filter_contents map[string][]string) []string {
+// Add the contents of filter_contents to the config document, as filters under the given account
+// Resource IDs are added as filters of their mapped aws-nuke resource type
+// Returns what the safety interlock expects aws-nuke to filter, also when it stops on a type which could not be mapped, for the report
+func generateResourceConfigSection(logger *zap.Logger, doc *nukeconfig.Document, account string, mapper *mapping.Mapper, registry *translate.Registry, companions *expand.Engine, options mappingOptions, filter_contents map[string][]string) ([]interlock.Expectation, error) {
 
     fmt.Println("\n\nNORMALISING RESOURCE TYPES:")
     fmt.Println()
     fmt.Println("The CFN API uses different resource type names to those accepted by aws-nuke. For each resource type which we want to preserve, we now attempt to map it to an aws-nuke resource type.")
-    fmt.Println("If there is no direct match, you will be presented with options to choose between.")
-    fmt.Println()
-
-    // Get the aws-nuke resource types
-    awsNukeResourceTypesTemp, err := exec.Command("bash", "-c", "aws-nuke resource-types").Output()
-    if err != nil {
-        panic(err)
+    if options.nonInteractive {
+        fmt.Printf("Mappings are taken from %s, then from the bundled catalog. Running non-interactively: types with no direct match follow the %q policy.\n", mapper.UserFile(), options.ambiguousPolicy)
+    } else {
+        fmt.Printf("Mappings are taken from %s, then from the bundled catalog. If neither has one and there is no direct match, you will be presented with options to choose between, and your choice is saved to %s.\n", mapper.UserFile(), mapper.UserFile())
     }
+    fmt.Println()
 
-    resourceTypesString := string(awsNukeResourceTypesTemp)
-    awsNukeResourceTypes := strings.Split(resourceTypesString, "\n")
     unmatchedResources := make(map[string][]string)
+    var ambiguousTypes []string
+    var expansions []expand.Expansion
+    var expectations []interlock.Expectation
 
-    // Certain aws-nuke resources can only be filtered in the config file through use of a specific property. customProperties maps resource type to the property (and optionally value).
-    // When we add the resource to the config file, we use the custom property from the map, if present, else just use the default mechanism.
-    // If the customProperties key has two elements, the first is the property, and the second the value
-    customProperties := make(map[string][]string)
-    customProperties["SNSTopic"] = append(customProperties["SNSTopic"], "TopicARN")
-    customProperties["CloudFormationStack"] = append(customProperties["CloudFormationStack"], "Name")
-    
-    for key, resources := range filter_contents {
+    // Types and resources are taken in sorted order, and duplicates dropped, so that the config is identical from run to run
+    for _, key := range helpers.SortedKeys(filter_contents) {
+        resources := helpers.SortedUnique(filter_contents[key])
         // Map the resource type to one supported by aws-nuke
-        
-        var allPossibleMatches []string
-        var chosenAwsNukeKey string
-
-        cleanedKey := strings.Replace(key, "AWS::", "", -1)
-        awsNukeKeySplit := strings.Split(cleanedKey, "::")
-        for i:=0 ; i<len(awsNukeKeySplit) ; i++ {
-            stringToSearch := ""
-
-            for j := i; j < len(awsNukeKeySplit); j++ {
-                stringToSearch += awsNukeKeySplit[j]
-            }
-            
-            logger.Debug(fmt.Sprintf("\nSearching aws-nuke resource types for %s\n", stringToSearch))
-
-            possibleMatches := helpers.FindItemAll(awsNukeResourceTypes, stringToSearch)
-
-            logger.Debug(fmt.Sprintf("\nPossible matches: %v\n", possibleMatches))
-            findIndex := helpers.FindItemExact(possibleMatches, stringToSearch)
-            if findIndex != -1 {
-                chosenAwsNukeKey = possibleMatches[findIndex]
-                fmt.Printf("Mapped %s to type %s\n", key, chosenAwsNukeKey)
-                break 
+        chosenAwsNukeKey, source, found := mapper.Lookup(key)
+        if found {
+            if chosenAwsNukeKey != "" {
+                fmt.Printf("Mapped %s to type %s (%s)\n", key, chosenAwsNukeKey, source)
             } else {
-                allPossibleMatches = append(allPossibleMatches, possibleMatches...)
-            }         
-        }
-
-        if chosenAwsNukeKey == "" && len(allPossibleMatches) != 0 {
-            // There was no exact mapping for this resource type, so let the user choose from a list of partial matches
-
-            var resourceTypeChoice string
-
-            allPossibleMatches = helpers.RemoveDuplicates[string](allPossibleMatches)
-
-            fmt.Printf("There was no exact mapping found for %s in the list of aws-nuke resource types. There were some partial matches.\n", key)
-            fmt.Println("Should you see the correct type in the list below, please type the corresponding number to use it for this mapping. If none, type -1. If -1, the resource will be omitted from the config file:")
-
-            for i, possibleMatch := range allPossibleMatches {
-                fmt.Printf("[%d] %s\n", i+1, possibleMatch)
-            }
-
-            fmt.Scanln(&resourceTypeChoice)
-            choiceInt, err := strconv.Atoi(resourceTypeChoice)
-            if err != nil {
-                fmt.Println("Invalid option. All resources of this type will therefore be omitted from the config file.")
+                fmt.Printf("%s is marked as not mapped in %s. All resources of this type will therefore be omitted from the config file.\n", key, mapper.UserFile())
             }
+        } else {
+            var allPossibleMatches []string
+            chosenAwsNukeKey, allPossibleMatches = mapper.Guess(key)
+            logger.Debug(fmt.Sprintf("\nPossible matches for %s: %v\n", key, allPossibleMatches))
 
-            if choiceInt != -1 {
-                if choiceInt > len(allPossibleMatches) || choiceInt<0{
-                    fmt.Println("Invalid option. All resources of this type will therefore be omitted from the config file.")
-                } else{
-                    chosenAwsNukeKey = allPossibleMatches[choiceInt-1]
+            if chosenAwsNukeKey != "" {
+                fmt.Printf("Mapped %s to type %s\n", key, chosenAwsNukeKey)
+            } else if len(allPossibleMatches) != 0 && options.nonInteractive {
+                // There was no exact mapping for this resource type, and nobody to ask. Every such type is listed before stopping
+                if options.ambiguousPolicy != ambiguousPolicySkip {
+                    fmt.Printf("No mapping for %s, which could be any of %v.\n", key, allPossibleMatches)
+                    ambiguousTypes = append(ambiguousTypes, key)
+                    unmatchedResources[key] = append(unmatchedResources[key], resources...)
+                    continue
                 }
-            } else{
-                fmt.Println("All mapping options refused. All resources of this type will therefore be omitted from the config file.")
+                fmt.Printf("No mapping for %s, which could be any of %v. Skipped, as the ambiguous mapping policy is %q. All resources of this type will therefore be omitted from the config file.\n", key, allPossibleMatches, options.ambiguousPolicy)
+            } else if len(allPossibleMatches) != 0 {
+                // There was no exact mapping for this resource type, so let the user choose from a list of partial matches
+                chosenAwsNukeKey = chooseAwsNukeType(key, allPossibleMatches)
+                mapper.Remember(key, chosenAwsNukeKey)
+            } else {
+                fmt.Printf("Failed to find any suitable aws-nuke resource type to map to %s. All resources of this type will therefore be omitted from the config file.\n", key)
             }
-        } else if chosenAwsNukeKey == "" && len(allPossibleMatches) == 0{
-            fmt.Printf("Failed to find any suitable aws-nuke resource type to map to %s. All resources of this type will therefore be omitted from the config file.\n", key)
         }
 
         if chosenAwsNukeKey != "" {
-            var filterContents []string
-            // Format and add the resource names of this type to the filterContents, ready to be written to the config file
-    
-            customProperty, customPropertyExists := customProperties[chosenAwsNukeKey]
-
+            var filterContents []nukeconfig.Filter
+            // Build a filter for each resource of this type, ready to be added to the config file.
+            // The CFN physical ID is not always what aws-nuke compares against, so it is translated per aws-nuke type
             for _, resource := range resources {
-                if customPropertyExists {
-                    if len(customProperty) ==1 {
-                        filterContents = append(filterContents, fmt.Sprintf("        - property: \"%s\"", customProperty[0]))
-                        filterContents = append(filterContents, fmt.Sprintf("          value: \"%s\"", resource))
-                    } else {
-                        filterContents = append(filterContents, fmt.Sprintf("        - property: \"%s\"", customProperty[0]))
-                        filterContents = append(filterContents, fmt.Sprintf("          value: \"%s\"", customProperty[1]))
-                    }
-                    
-                } else {
-                    filterContents = append(filterContents, fmt.Sprintf("        - \"%s\"", resource))
+                filter, err := registry.Filter(chosenAwsNukeKey, resource)
+                if err != nil {
+                    return nil, err
                 }
+                filterContents = append(filterContents, filter)
+                expectations = append(expectations, interlock.Expectation{AwsNukeType: chosenAwsNukeKey, CFNType: key, Identity: resource, Filter: filter, Source: key})
             }
 
             // Add the resources for preservation to the correct resource section of the file, under filters
-            index_of_resource_block := helpers.FindItem(lines, fmt.Sprintf("%s:", chosenAwsNukeKey))
-            indexToInsert := index_of_resource_block + 1
-            lines = append(lines[:indexToInsert], append(filterContents, lines[indexToInsert:]...)...)
-            logger.Debug(fmt.Sprintf("New contents of the config file: %v", lines))
-
-            if chosenAwsNukeKey == "IAMRole" {
-                // The IAMRole resources are a special case, whereby if we do nothing, their associated IAMRolePolicy and IAMRolePolicyAttachment resources won't also be preserved.
-                // We must therefore add them to the file here.
-                var iamRolePolicyContents []string
-                var iamRolePolicyAttachmentContents []string
-                for _, resource := range resources {
-                    iamRolePolicyContents = append(iamRolePolicyContents, "        - property: role:RoleName")
-                    iamRolePolicyContents = append(iamRolePolicyContents, fmt.Sprintf("          value: %s", resource))
-
-                    iamRolePolicyAttachmentContents = append(iamRolePolicyAttachmentContents, "        - property: RoleName")
-                    iamRolePolicyAttachmentContents = append(iamRolePolicyAttachmentContents, fmt.Sprintf("          value: %s", resource))
-                }
-
-                index_of_iamrolepolicy_block := helpers.FindItem(lines, fmt.Sprintf("%s:", "IAMRolePolicy"))
-                indexToInsert = index_of_iamrolepolicy_block + 1
-                lines = append(lines[:indexToInsert], append(iamRolePolicyContents, lines[indexToInsert:]...)...)
-
-                index_of_iamrolepolicyattachment_block := helpers.FindItem(lines, fmt.Sprintf("%s:", "IAMRolePolicyAttachment"))
-                indexToInsert = index_of_iamrolepolicyattachment_block + 1
-                lines = append(lines[:indexToInsert], append(iamRolePolicyAttachmentContents, lines[indexToInsert:]...)...)
+            if err := doc.AddFilters(account, chosenAwsNukeKey, filterContents...); err != nil {
+                return nil, err
+            }
+            logger.Debug(fmt.Sprintf("Added filters for %s: %v", chosenAwsNukeKey, filterContents))
 
-                logger.Debug(fmt.Sprintf("New contents of the config file: %v", lines))
+            // Preserve the related resources which the rules list as companions of this type, e.g. the policies of an IAMRole,
+            // as they would otherwise be nuked from under the preserved resource
+            for _, resource := range resources {
+                resourceExpansions, err := companions.Expand(chosenAwsNukeKey, expand.TemplateData{ID: resource, Account: account})
+                if err != nil {
+                    return nil, err
+                }
+                for _, expansion := range resourceExpansions {
+                    if err := doc.AddFilters(account, expansion.Type, expansion.Filter); err != nil {
+                        return nil, err
+                    }
+                    expectations = append(expectations, interlock.Expectation{
+                        AwsNukeType: expansion.Type,
+                        Identity:    expansion.SourceID,
+                        Filter:      expansion.Filter,
+                        Source:      fmt.Sprintf("companion of %s %s", expansion.SourceType, expansion.SourceID),
+                    })
+                }
+                expansions = append(expansions, resourceExpansions...)
             }
-        
-            
         } else {
             // An aws-nuke resource type wasn't matched. Add this to a slice to deal with later
             unmatchedResources[key] = append(unmatchedResources[key], resources...)
@@ -225,79 +228,352 @@ func generateResourceConfigSection(logger *zap.Logger, lines []string, filter_co
         
     }
 
+    saved, err := mapper.Save()
+    if err != nil {
+        return nil, err
+    }
+    if saved {
+        fmt.Printf("\nSaved your mapping choices to %s. They will be reused on later runs.\n", mapper.UserFile())
+    }
+
+    if len(expansions) != 0 {
+        fmt.Println("\n\nCOMPANION RESOURCES TO PRESERVE:")
+        fmt.Println()
+        fmt.Println("Related resources preserved alongside the resources above, as listed under companions in the rules:")
+        for _, expansion := range expansions {
+            fmt.Printf("- %s %s -> %s %s\n", expansion.SourceType, expansion.SourceID, expansion.Type, expansion.Filter.Describe())
+        }
+    }
+
     fmt.Println("\n\nResource type normalisation complete.")
     if len(unmatchedResources) != 0 {
         fmt.Println("The following resources (grouped by type) could not be mapped, they have therefore NOT been added to the config file:")
-        for unmatchedResourceType, unmatchedResourceName := range(unmatchedResources) {
-            fmt.Printf("- %s: %s\n", unmatchedResourceType, unmatchedResourceName)
+        for _, unmatchedResourceType := range helpers.SortedKeys(unmatchedResources) {
+            fmt.Printf("- %s: %s\n", unmatchedResourceType, unmatchedResources[unmatchedResourceType])
         }
         fmt.Println("\nIt may be that the resource types are not supported by aws-nuke, and therefore resources of this type will not be deleted.")
         fmt.Println("Run aws-nuke resource-types to see the full list of supported types.")
+
+        if len(ambiguousTypes) != 0 {
+            return expectations, fmt.Errorf("%w: %v have no mapping, and could be any of several aws-nuke types. Add them to %s", errMappingIncomplete, ambiguousTypes, mapper.UserFile())
+        }
+        if options.failOnUnmapped {
+            return expectations, fmt.Errorf("%w: %d resource types could not be mapped, and -fail-on-unmapped was given", errMappingIncomplete, len(unmatchedResources))
+        }
     }
     
     fmt.Println("\nPlease ensure that you review the generated config file, and review the resources aws-nuke marks for deletion before confirming the deletion!")    
-    return lines
+    return expectations, nil
 }
 
-func GenerateResourceTypeConfigSection(logger *zap.Logger, lines []string, resourceTypesToFilter []string) []string {
-	var filterContents []string
-	
-	for _, resourceType := range resourceTypesToFilter {
-		if len(resourceType) != 0 {
-			filterContents = append(filterContents, fmt.Sprintf("  - %s", resourceType))
-		}
-	}
-
-    // Append the new section to the file
-    indexOfResourceTypesBlock := helpers.FindItem(lines, "excludes:")
-    if indexOfResourceTypesBlock != -1 {
-        indexToInsert := indexOfResourceTypesBlock + 1
-        lines = append(lines[:indexToInsert], append(filterContents, lines[indexToInsert:]...)...)
+// Ask the user which of the partial matches to map cfnType to. Returns an empty string if none is chosen
+func chooseAwsNukeType(cfnType string, allPossibleMatches []string) string {
+    var resourceTypeChoice string
 
-    } else {
-        filterContents = append([]string{"  excludes:"}, filterContents...)
-        filterContents = append([]string{"resource-types:"}, filterContents...)
-        lines = append(lines, filterContents...)
+    fmt.Printf("There was no exact mapping found for %s in the list of aws-nuke resource types. There were some partial matches.\n", cfnType)
+    fmt.Println("Should you see the correct type in the list below, please type the corresponding number to use it for this mapping. If none, type -1. If -1, the resource will be omitted from the config file:")
+
+    for i, possibleMatch := range allPossibleMatches {
+        fmt.Printf("[%d] %s\n", i+1, possibleMatch)
     }
-    logger.Debug(fmt.Sprintf("Added resource-types section for preservation of specified resources: %v", lines))
 
-    return lines
-	
+    fmt.Scanln(&resourceTypeChoice)
+    choiceInt, err := strconv.Atoi(resourceTypeChoice)
+    if err != nil {
+        fmt.Println("Invalid option. All resources of this type will therefore be omitted from the config file.")
+        return ""
+    }
+
+    if choiceInt == -1 {
+        fmt.Println("All mapping options refused. All resources of this type will therefore be omitted from the config file.")
+        return ""
+    }
+    if choiceInt > len(allPossibleMatches) || choiceInt < 1 {
+        fmt.Println("Invalid option. All resources of this type will therefore be omitted from the config file.")
+        return ""
+    }
+    return allPossibleMatches[choiceInt-1]
 }
 
-func GenerateRolePolicyPreservationSection(logger *zap.Logger, lines []string, resourceTypesToFilter []string) []string {
-	var filterContents []string
-	
-	for _, resourceType := range resourceTypesToFilter {
-		if len(resourceType) != 0 {
-			filterContents = append(filterContents, fmt.Sprintf("  - %s", resourceType))
-		}
-	}
-
-    // Append the new section to the file
-    indexOfResourceTypesBlock := helpers.FindItem(lines, "resource-types:")
-    if indexOfResourceTypesBlock != -1 {
-        indexToInsert := indexOfResourceTypesBlock + 1
-        lines = append(lines[:indexToInsert], append(filterContents, lines[indexToInsert:]...)...)
-    } else {
-        filterContents = append([]string{"  excludes:"}, filterContents...)
-        filterContents = append([]string{"resource-types:"}, filterContents...)
-        lines = append(lines, filterContents...)
+// Print the effective CFN to aws-nuke type mapping table: the mapping file entries, then the bundled catalog entries which they do not override
+func printMappings(mapper *mapping.Mapper, registry *translate.Registry, companions map[string][]expand.Rule) {
+    fmt.Printf("%-50s %-40s %s\n", "CLOUDFORMATION TYPE", "AWS-NUKE TYPE", "SOURCE")
+    for _, entry := range mapper.Table() {
+        awsNukeType := entry.AwsNukeType
+        if awsNukeType == "" {
+            awsNukeType = "(not mapped)"
+        } else if !mapper.Supported(awsNukeType) {
+            awsNukeType += " (unsupported by installed aws-nuke)"
+        }
+        fmt.Printf("%-50s %-40s %s\n", entry.CFNType, awsNukeType, entry.Source)
     }
-    logger.Debug(fmt.Sprintf("Added resource-types section for preservation of specified resources: %v", lines))
 
-    return lines
-	
+    // Then how the physical IDs of each aws-nuke type are turned into filters
+    fmt.Printf("\n%-40s %-60s %s\n", "AWS-NUKE TYPE", "FILTER ON", "SOURCE")
+    for _, awsNukeType := range registry.Types() {
+        translator, _ := registry.Translator(awsNukeType)
+        source := "built-in"
+        if registry.Overridden(awsNukeType) {
+            source = "rules file"
+        }
+        fmt.Printf("%-40s %-60v %s\n", awsNukeType, translator, source)
+    }
+    fmt.Println("All other types are filtered on their resource ID, exactly matching the CFN physical ID")
+
+    // Then the companions preserved alongside each preserved resource
+    var companionTypes []string
+    for awsNukeType := range companions {
+        companionTypes = append(companionTypes, awsNukeType)
+    }
+    sort.Strings(companionTypes)
+    fmt.Printf("\n%-40s %s\n", "PRESERVED TYPE", "COMPANION FILTER")
+    for _, awsNukeType := range companionTypes {
+        for _, companion := range companions[awsNukeType] {
+            fmt.Printf("%-40s %v\n", awsNukeType, companion)
+        }
+    }
+}
+
+// Load the rules files. Without -rules, shield-rules.yml is used, falling back to the copy bundled into Shield when it does not exist
+func loadRules(rulesFiles []string) (*rules.Rules, error) {
+    if len(rulesFiles) != 0 {
+        return rules.Load(rulesFiles...)
+    }
+    if _, err := os.Stat(rules.DefaultRulesFile); err == nil {
+        return rules.Load(rules.DefaultRulesFile)
+    }
+    return rules.Parse(defaultRulesYAML)
+}
+
+// Run aws-nuke with the config file and return the events of its output. In text mode the output is echoed as aws-nuke prints it,
+// in JSON mode each event is printed as a JSON line instead, and the other lines go to stderr. Without interactive, aws-nuke is run
+// with --force, as nobody can answer its confirmation prompt. The dry run never deletes anything, so is always forced
+func runAwsNuke(configFile string, noDryRun bool, interactive bool, outputFormat string) ([]nukeoutput.Event, error) {
+    nukeArgs := fmt.Sprintf("aws-nuke -c %v", configFile)
+    if noDryRun {
+        nukeArgs += " --no-dry-run"
+    }
+    if !noDryRun || !interactive {
+        nukeArgs += " --force --force-sleep 3"
+    }
+
+    cmd := exec.Command("bash", "-c", nukeArgs)
+    if noDryRun && interactive {
+        cmd.Stdin = os.Stdin
+    }
+    cmd.Stderr = os.Stderr
+    stdout, err := cmd.StdoutPipe()
+    if err != nil {
+        return nil, err
+    }
+    if err := cmd.Start(); err != nil {
+        return nil, err
+    }
+
+    // Echo the text as it is read, rather than by line, so that the confirmation prompt is shown before it is answered
+    var output io.Reader = stdout
+    if outputFormat != nukeOutputJSON {
+        output = io.TeeReader(stdout, os.Stdout)
+    }
+
+    var events []nukeoutput.Event
+    // How many resources aws-nuke is to remove, from the totals of the scan
+    nukeable := -1
+    encoder := json.NewEncoder(os.Stdout)
+    scanErr := nukeoutput.Scan(output, func(line string, event nukeoutput.Event, ok bool) error {
+        if ok {
+            events = append(events, event)
+        }
+        switch event.Kind {
+        case nukeoutput.KindScanComplete:
+            nukeable = event.Counts["nukeable"]
+        case nukeoutput.KindRemovalStatus:
+            printProgress(event, nukeable)
+        }
+        if outputFormat != nukeOutputJSON {
+            return nil
+        }
+        if !ok {
+            // Keep the lines Shield does not understand, such as aws-nuke's banner, out of the JSON stream
+            _, err := fmt.Fprintln(os.Stderr, line)
+            return err
+        }
+        return encoder.Encode(event)
+    })
+    if err := cmd.Wait(); err != nil {
+        return events, err
+    }
+    return events, scanErr
+}
+
+// Print how far removal has got after a removal round. It goes to stderr, so that it is also shown with -nuke-output json
+func printProgress(status nukeoutput.Event, nukeable int) {
+    removed := fmt.Sprintf("%d", status.Counts["finished"])
+    if nukeable >= 0 {
+        removed += fmt.Sprintf(" of %d", nukeable)
+    }
+    fmt.Fprintf(os.Stderr, "SHIELD PROGRESS: %s resources removed, %d waiting, %d failed\n", removed, status.Counts["waiting"], status.Counts["failed"])
+}
+
+// Print how many resources aws-nuke left in each state, and which it failed to remove
+func printNukeSummary(events []nukeoutput.Event) {
+    summary := nukeoutput.Summarise(events)
+    fmt.Println("\n\nAWS-NUKE SUMMARY:")
+    fmt.Println()
+    for _, state := range nukeoutput.States {
+        if summary.States[state] != 0 {
+            fmt.Printf("%-14s %d\n", state, summary.States[state])
+        }
+    }
+    failed := summary.InState(nukeoutput.StateFailed)
+    if len(failed) != 0 {
+        fmt.Println()
+        fmt.Println("Resources aws-nuke failed to remove:")
+        for _, event := range failed {
+            fmt.Printf("- %s - %s - %s - %s\n", event.Region, event.ResourceType, event.ID, event.Message)
+        }
+    }
+}
+
+// Hash what the preserved resources are derived from: the flags given, the rules files and the mapping file
+func preservationInputsHash(flags *flag.FlagSet, rulesFiles []string, mappingFile string) (string, error) {
+    var parts [][]byte
+    flags.Visit(func(f *flag.Flag) {
+        if helpers.FindItemExact(nonPreservationFlags, f.Name) == -1 {
+            parts = append(parts, []byte(fmt.Sprintf("flag -%s=%s", f.Name, f.Value.String())))
+        }
+    })
+
+    if len(rulesFiles) == 0 {
+        if _, err := os.Stat(rules.DefaultRulesFile); err == nil {
+            rulesFiles = []string{rules.DefaultRulesFile}
+        } else {
+            parts = append(parts, append([]byte("rules[0] "), defaultRulesYAML...))
+        }
+    }
+    // Later rules files override earlier ones, so their order is part of the hash
+    for i, rulesFile := range rulesFiles {
+        data, err := os.ReadFile(rulesFile)
+        if err != nil {
+            return "", err
+        }
+        parts = append(parts, append([]byte(fmt.Sprintf("rules[%d] ", i)), data...))
+    }
+
+    // The mapping file need not exist
+    data, err := os.ReadFile(mappingFile)
+    if err != nil && !errors.Is(err, fs.ErrNotExist) {
+        return "", err
+    }
+    parts = append(parts, append([]byte("mapping "), data...))
+    return plan.Hash(parts...), nil
+}
+
+// Print the resources which differ between the plan and the current dry run
+func printPlanDifferences(added []plan.Deletion, dropped []plan.Deletion) {
+    fmt.Println("\n\n!!!!!!!!!! THE DRY RUN DOES NOT MATCH THE PLAN !!!!!!!!!!")
+    if len(added) != 0 {
+        fmt.Println()
+        fmt.Println("aws-nuke would now also remove:")
+        for _, deletion := range added {
+            fmt.Printf("+ %s\n", deletion)
+        }
+    }
+    if len(dropped) != 0 {
+        fmt.Println()
+        fmt.Println("aws-nuke would no longer remove:")
+        for _, deletion := range dropped {
+            fmt.Printf("- %s\n", deletion)
+        }
+    }
+}
+
+// Print exactly which regions and stacks could not be inspected
+func printDiscoveryErrors(discoveryErrors []resources.DiscoveryError) {
+    fmt.Println("\n\n!!!!!!!!!! DISCOVERY INCOMPLETE !!!!!!!!!!")
+    fmt.Println()
+    fmt.Println("The following could not be inspected. Resources in them are NOT protected:")
+    for _, discoveryError := range discoveryErrors {
+        fmt.Printf("- %v\n", discoveryError)
+    }
+}
+
+// Write the preservation report to the JSON and Markdown files given, if any. stopErr is the error a run stopped on before aws-nuke was run,
+// which is recorded in the report, so that the report shows how far it got
+func writeReport(preservationReport *report.Report, discoveryErrors []resources.DiscoveryError, reportFile string, reportMarkdownFile string, stopErr error) error {
+    if reportFile == "" && reportMarkdownFile == "" {
+        return nil
+    }
+    for _, discoveryError := range discoveryErrors {
+        preservationReport.DiscoveryErrors = append(preservationReport.DiscoveryErrors, report.DiscoveryError{Region: discoveryError.Region, Stack: discoveryError.Stack, Error: discoveryError.Err.Error()})
+    }
+    if stopErr != nil {
+        preservationReport.Error = stopErr.Error()
+    }
+    preservationReport.Sort()
+
+    if reportFile != "" {
+        if err := preservationReport.WriteJSON(reportFile); err != nil {
+            return err
+        }
+        fmt.Printf("Wrote the preservation report to %s\n", reportFile)
+    }
+    if reportMarkdownFile != "" {
+        if err := preservationReport.WriteMarkdown(reportMarkdownFile); err != nil {
+            return err
+        }
+        fmt.Printf("Wrote the preservation report to %s\n", reportMarkdownFile)
+    }
+    return nil
+}
+
+// Print the error and stop Shield before aws-nuke is run, with an exit code describing the failure
+func exitWithError(err error) {
+    fmt.Fprintf(os.Stderr, "\nERROR: %v\n", err)
+    if errors.Is(err, errMappingIncomplete) {
+        os.Exit(exitMappingIncomplete)
+    }
+    if errors.Is(err, errNukeFailed) {
+        os.Exit(exitNukeFailed)
+    }
+    if errors.Is(err, errInterlockFailed) {
+        os.Exit(exitInterlockFailed)
+    }
+    if errors.Is(err, errPlanMismatch) {
+        os.Exit(exitPlanMismatch)
+    }
+    os.Exit(exitError)
 }
 
 func main() {
-    var stacksRegexes helpers.StringListFlag
-    var resourceTags helpers.StringListFlag
+    var stackOpts stackOptions
+    var stackStatusNames helpers.StringListFlag
+    var resourceTags resources.TagSelectorListFlag
     var resourceTypesToFilter helpers.StringListFlag
+    var regionOverride helpers.StringListFlag
+    var discoverRegions bool
+    var allTags bool
+    var preserveRetained bool
+    var noProtectCaller bool
+    var stackDependencies []resources.StackDependency
+    var retainedToPreserve []resources.StackResource
+    var taggedToPreserve []resources.TaggedResource
+    var allowPartialDiscovery bool
+    var allowNotSeen bool
+    var discoveryErrors []resources.DiscoveryError
     var noDryRun bool
-    var stackIdsFiltered []string
+    var nukeOutput string
+    var stacksFiltered []resources.Stack
+    var childrenToPreserve []resources.StackResource
     var configFile string
     var generatedConfigFile string
+    var mappingFile string
+    var mappingOpts mappingOptions
+    var rulesFiles helpers.StringListFlag
+    var planFile string
+    var reportFile string
+    var reportMarkdownFile string
+    var approvedPlan plan.Plan
     resourcesToPreserveByType := make(map[string][]string)
 
     // Configure logging options
@@ -318,96 +594,636 @@ func main() {
     }
     defer logger.Sync()
 
+    // The mappings command prints the effective CFN to aws-nuke type mapping table
+    if len(os.Args) > 1 && os.Args[1] == "mappings" {
+        mappingsFlags := flag.NewFlagSet("mappings", flag.ExitOnError)
+        mappingsFlags.StringVar(&mappingFile, "mapping-file", mapping.DefaultMappingFile, "File holding the user's CFN to aws-nuke type mappings")
+        mappingsFlags.Var(&rulesFiles, "rules", "List of Shield rules files, merged in order. Defaults to shield-rules.yml")
+        mappingsFlags.Parse(os.Args[2:])
+
+        // Without aws-nuke installed, the table is printed without checking which types it supports
+        awsNukeResourceTypes, _ := awsnuke.ResourceTypes()
+        mapper, err := mapping.NewMapper(mappingFile, awsNukeResourceTypes)
+        if err != nil {
+            exitWithError(err)
+        }
+        shieldRules, err := loadRules(rulesFiles)
+        if err != nil {
+            exitWithError(err)
+        }
+        registry, err := translate.NewRegistry(shieldRules.Identities)
+        if err != nil {
+            exitWithError(err)
+        }
+        printMappings(mapper, registry, shieldRules.Companions)
+        return
+    }
+
+    // plan and apply take the same flags as a plain run
+    command := ""
+    args := os.Args[1:]
+    if len(args) > 0 && (args[0] == commandPlan || args[0] == commandApply) {
+        command = args[0]
+        args = args[1:]
+    }
+
     // Get CLI args
     flag.StringVar(&configFile, "config", "example-nuke-config.yml", "Base config file to use")
-    flag.Var(&stacksRegexes, "regexes", "List of regexes to use to match cfn stack IDs")
-    flag.Var(&resourceTags, "tags", "List of tags in key:value format. All resources with these tags will be preserved")
+    flag.Var(&stackOpts.regexes, "regexes", "List of regexes to use to match cfn stack IDs")
+    flag.Var(&stackOpts.excludeRegexes, "exclude-regexes", "List of regexes of stack names which are not preserved, even though they match -regexes")
+    flag.Var(&stackOpts.tags, "stack-tags", "List of tag selectors (as for -tags) matching the tags of stacks to preserve")
+    flag.Var(&stackOpts.stackSets, "stacksets", "List of StackSet names. Their instance stacks in the account are preserved, found with the StackSet APIs")
+    flag.BoolVar(&stackOpts.stackSetNameFallback, "stackset-name-fallback", false, "Also match the instance stacks of the -stacksets by their StackSet-<name>-<uuid> names, for StackSets administered from another account, such as service-managed StackSets. WARNING any stack so named is preserved")
+    flag.BoolVar(&stackOpts.terminationProtected, "termination-protected", false, "Preserve stacks which have termination protection enabled")
+    flag.Var(&stackOpts.descriptionRegexes, "description-regexes", "List of regexes matching the descriptions of stacks to preserve")
+    flag.Var(&stackOpts.roleRegexes, "stack-roles", "List of regexes matching the ARN of the IAM service role of stacks to preserve")
+    flag.StringVar(&stackOpts.createdAfter, "created-after", "", "Only preserve stacks created after this time: an RFC 3339 time, a date (2006-01-02) or an age such as 36h or 30d")
+    flag.StringVar(&stackOpts.createdBefore, "created-before", "", "Only preserve stacks created before this time, in the same formats as -created-after")
+    flag.StringVar(&stackOpts.updatedAfter, "updated-after", "", "Only preserve stacks last updated after this time, in the same formats as -created-after")
+    flag.StringVar(&stackOpts.updatedBefore, "updated-before", "", "Only preserve stacks last updated before this time, in the same formats as -created-after")
+    flag.Var(&stackStatusNames, "stack-statuses", fmt.Sprintf("List of CFN stack statuses to consider when matching regexes. Defaults to the statuses of live stacks: %s", strings.Join(resources.DefaultStackStatuses, ", ")))
+    flag.Var(&resourceTags, "tags", "List of tag selectors. All resources with matching tags will be preserved: key (any value), key:value (exact), key:va*e (glob), key:/re/ (regex) or key:~part (contains), with (?i) before the value for case-insensitive matching")
     flag.Var(&resourceTypesToFilter, "preserve-resource-types", "List of resource-types, in format accepted by aws-nuke. No resources of these types will be nuked")
+    flag.Var(&regionOverride, "regions", "List of regions to search for CFN stacks. Defaults to the regions of the base config")
+    flag.BoolVar(&allTags, "all-tags", false, "Preserve only the resources carrying ALL of the -tags, found with the Resource Groups Tagging API, instead of every resource carrying any of them. Resources of types the Tagging API cannot find are still preserved when carrying any of the tags")
+    flag.BoolVar(&noProtectCaller, "no-protect-caller", false, "Do not preserve the IAM role or user Shield runs as, with its policies, instance profiles and groups. WARNING aws-nuke may then delete the identity it runs as, cutting the run off halfway")
+    flag.BoolVar(&preserveRetained, "preserve-retained", false, "Also preserve the resources retained (DeletionPolicy: Retain) from deleted stacks whose names are selected, for stacks deleted within the last 90 days")
+    flag.BoolVar(&discoverRegions, "discover-regions", false, "Search every region enabled in the account for CFN stacks, instead of the regions of the base config")
+    flag.StringVar(&mappingFile, "mapping-file", mapping.DefaultMappingFile, "File holding the user's CFN to aws-nuke type mappings. Choices made when prompted are saved to it")
+    flag.Var(&rulesFiles, "rules", "List of Shield rules files (static filters, identity rules and companion rules), merged in order. Defaults to shield-rules.yml, or the bundled copy of it if that file does not exist")
+    flag.BoolVar(&mappingOpts.nonInteractive, "non-interactive", false, "Never prompt, e.g. when running in a CI pipeline. Ambiguous type mappings follow -ambiguous-mappings, and aws-nuke is run with --force")
+    flag.StringVar(&mappingOpts.ambiguousPolicy, "ambiguous-mappings", ambiguousPolicyFail, "With -non-interactive, what to do with a CFN type which has several candidate aws-nuke types and no mapping: fail, skip, or mapping-file (fail, and require the mapping file to exist)")
+    flag.BoolVar(&mappingOpts.failOnUnmapped, "fail-on-unmapped", false, "Stop without running aws-nuke if any resource to preserve could not be mapped to an aws-nuke type")
+    flag.BoolVar(&allowPartialDiscovery, "allow-partial-discovery", false, "Allow -no-dry-run even though some regions or stacks could not be inspected. WARNING resources in those regions and stacks are not protected")
+    flag.BoolVar(&allowNotSeen, "allow-not-seen", false, "Let the run go ahead even though the dry run did not report some preserved resources, of types aws-nuke scans, at all. WARNING whether they are preserved is then unknown")
+    flag.StringVar(&nukeOutput, "nuke-output", nukeOutputText, "How aws-nuke's output is shown: text, as aws-nuke prints it, or json, each resource and summary line as a JSON object on its own line. With json, -no-dry-run requires -non-interactive")
     flag.BoolVar(&noDryRun, "no-dry-run", false, "Runs aws-nuke with the no-dry-run flag. WARNING this will cause aws-nuke to actually delete the resources in your account")
-    flag.Parse()
+    flag.StringVar(&planFile, "plan-file", plan.DefaultPlanFile, fmt.Sprintf("With plan or apply, the plan file to write or check against. Plans are signed with the key in %s", plan.KeyVariable))
+    flag.StringVar(&reportFile, "report", "", "Write a JSON report of every stack and resource preserved, why, and the filter emitted for it, along with unmapped resources and discovery errors")
+    flag.StringVar(&reportMarkdownFile, "report-markdown", "", "Write the same report as Markdown")
+    flag.CommandLine.Parse(args)
 
     generatedConfigFile = fmt.Sprintf("%v-shield-generated", configFile)
 
+    if err := mappingOpts.validate(); err != nil {
+        exitWithError(err)
+    }
+    if err := validateNukeOutput(nukeOutput); err != nil {
+        exitWithError(err)
+    }
+    var planKey []byte
+    if command != "" {
+        planKey, err = plan.Key()
+        if err != nil {
+            exitWithError(err)
+        }
+    }
+    if command == commandPlan && noDryRun {
+        exitWithError(fmt.Errorf("plan never deletes anything, run apply with the plan instead of -no-dry-run"))
+    }
+    // apply deletes what the plan lists, once it has checked that nothing has changed since
+    if command == commandApply {
+        noDryRun = true
+        approvedPlan, err = plan.Load(planFile, planKey)
+        if err != nil {
+            exitWithError(err)
+        }
+        baseConfigHash, err := plan.HashFile(configFile)
+        if err != nil {
+            exitWithError(err)
+        }
+        if baseConfigHash != approvedPlan.BaseConfigHash {
+            exitWithError(fmt.Errorf("%w: the base config %s has changed since the plan was made", errPlanMismatch, configFile))
+        }
+        fmt.Printf("APPLYING PLAN %s, made %s for account %s, removing %d resources\n\n", planFile, approvedPlan.CreatedAt.Format(time.RFC3339), approvedPlan.Account, len(approvedPlan.Deletions))
+    }
+    // aws-nuke's confirmation prompt is not part of the JSON output, so could not be answered
+    if nukeOutput == nukeOutputJSON && (noDryRun || command == commandApply) && !mappingOpts.nonInteractive {
+        exitWithError(fmt.Errorf("-nuke-output %s with -no-dry-run requires -non-interactive", nukeOutputJSON))
+    }
+    if mappingOpts.nonInteractive && mappingOpts.ambiguousPolicy == ambiguousPolicyMappingFile {
+        if _, err := os.Stat(mappingFile); err != nil {
+            err = fmt.Errorf("-ambiguous-mappings %s requires the mapping file, %w", ambiguousPolicyMappingFile, err)
+            // Nothing has been discovered yet, so the report only records why the run stopped
+            if reportErr := writeReport(report.New("", configFile, ""), nil, reportFile, reportMarkdownFile, err); reportErr != nil {
+                fmt.Fprintf(os.Stderr, "\nERROR: unable to write the preservation report, %v\n", reportErr)
+            }
+            exitWithError(err)
+        }
+    }
+
+    if len(stackStatusNames) == 0 {
+        stackStatusNames = resources.DefaultStackStatuses
+    }
+    stackStatuses, err := resources.ParseStackStatuses(stackStatusNames)
+    if err != nil {
+        exitWithError(err)
+    }
+    // Tag selectors are checked before anything is generated
+    tagFilters, err := resources.ParseTagSelectors(resourceTags)
+    if err != nil {
+        exitWithError(err)
+    }
+    if allTags && len(tagFilters) == 0 {
+        exitWithError(fmt.Errorf("-all-tags requires -tags"))
+    }
+    // Stack selectors are compiled once, and checked before any AWS call
+    stackCriteria, err := stackOpts.criteria(time.Now())
+    if err != nil {
+        exitWithError(err)
+    }
+    stackSelector, err := resources.NewStackSelector(stackCriteria)
+    if err != nil {
+        exitWithError(err)
+    }
+
     fmt.Println("PROVIDED REGEXES:")
-    for _, regex := range stacksRegexes {
+    for _, regex := range stackOpts.regexes {
         fmt.Printf("\n%v", regex)
     }
-    logger.Debug(fmt.Sprintf("Provided CFN stack regexes: %v", stacksRegexes))
+    if len(stackOpts.excludeRegexes) != 0 {
+        fmt.Println("\n\nPROVIDED EXCLUDE REGEXES:")
+        for _, regex := range stackOpts.excludeRegexes {
+            fmt.Printf("\n%v", regex)
+        }
+    }
+    logger.Debug(fmt.Sprintf("Provided CFN stack regexes: %v, excluding: %v", stackOpts.regexes, stackOpts.excludeRegexes))
+
+    // Read the provided config file
+    doc, err := nukeconfig.Load(configFile)
+    if err != nil {
+        exitWithError(err)
+    }
+    baseConfig, err := doc.Config()
+    if err != nil {
+        exitWithError(err)
+    }
+
+    regions, regionSource, err := selectRegions(logger, baseConfig.Regions, regionOverride, discoverRegions)
+    if err != nil {
+        exitWithError(err)
+    }
 
     fmt.Println("\n\nREGIONS TO SEARCH:")
     fmt.Println()
-    fmt.Printf("%s\n", aws_regions)
+    fmt.Printf("%s (from the %s)\n", regions, regionSource)
+    warnOnRegionMismatch(baseConfig.Regions, regions)
+
+    // Filters are added under the account which the current credentials belong to, as that is the account aws-nuke will run against
+    callerIdentity, err := resources.GetCallerIdentity(logger, apiRegion(regions))
+    if err != nil {
+        exitWithError(err)
+    }
+    accountID := callerIdentity.Account
+    fmt.Printf("\n\nTARGET ACCOUNT: %s\n", accountID)
+    if command == commandApply && accountID != approvedPlan.Account {
+        exitWithError(fmt.Errorf("%w: the plan was made for account %s, not %s", errPlanMismatch, approvedPlan.Account, accountID))
+    }
+    preservationReport := report.New(accountID, configFile, generatedConfigFile)
+    // Runs which stop before aws-nuke is run still write the report, as far as they got
+    exitWithReport := func(err error) {
+        if reportErr := writeReport(preservationReport, discoveryErrors, reportFile, reportMarkdownFile, err); reportErr != nil {
+            fmt.Fprintf(os.Stderr, "\nERROR: unable to write the preservation report, %v\n", reportErr)
+        }
+        exitWithError(err)
+    }
+
+    // The type mappings and rules are needed from the caller identity on, to tell which companions protect it
+    awsNukeResourceTypes, err := awsnuke.ResourceTypes()
+    if err != nil {
+        exitWithError(err)
+    }
+    mapper, err := mapping.NewMapper(mappingFile, awsNukeResourceTypes)
+    if err != nil {
+        exitWithError(err)
+    }
+    shieldRules, err := loadRules(rulesFiles)
+    if err != nil {
+        exitWithError(err)
+    }
+    registry, err := translate.NewRegistry(shieldRules.Identities)
+    if err != nil {
+        exitWithError(err)
+    }
+
+    // aws-nuke would cut the run off halfway by deleting the principal running it, so it is preserved unless explicitly asked not to
+    fmt.Println("\n\nCALLER IDENTITY PROTECTION:")
+    fmt.Println()
+    fmt.Printf("Running as %s\n", callerIdentity.ARN)
+    if noProtectCaller {
+        fmt.Println("WARNING: -no-protect-caller was given, so this identity is NOT protected")
+    } else if callerIdentity.PrincipalType == "" {
+        fmt.Println("This is not an IAM role or user, so there is nothing to protect")
+    } else {
+        callerResources, err := resources.GetCallerResources(logger, callerIdentity, apiRegion(regions))
+        if err != nil {
+            discoveryErrors = append(discoveryErrors, resources.DiscoveryError{Region: resources.GlobalRegion, Err: fmt.Errorf("unable to inspect the caller identity, %w", err)})
+        }
+        for _, callerResource := range callerResources {
+            fmt.Printf("- %s %s (%s)\n", callerResource.CFNType, callerResource.PhysicalID, callerResource.Reason)
+            resourcesToPreserveByType[callerResource.CFNType] = append(resourcesToPreserveByType[callerResource.CFNType], callerResource.PhysicalID)
+            preservationReport.AddResource(report.Resource{
+                Region:     resources.GlobalRegion,
+                CFNType:    callerResource.CFNType,
+                PhysicalID: callerResource.PhysicalID,
+                Reason:     report.ReasonCaller,
+                Detail:     callerResource.Reason,
+            })
+        }
+        // Their inline policies and policy attachments are only preserved if the rules have companions for them
+        for _, callerResource := range callerResources {
+            if callerResource.CFNType != "AWS::IAM::Role" && callerResource.CFNType != "AWS::IAM::User" && callerResource.CFNType != "AWS::IAM::Group" {
+                continue
+            }
+            awsNukeType, _, found := mapper.Lookup(callerResource.CFNType)
+            if !found || awsNukeType == "" {
+                continue
+            }
+            var companionTypes []string
+            for _, companion := range shieldRules.Companions[awsNukeType] {
+                companionTypes = append(companionTypes, companion.Type)
+            }
+            if len(companionTypes) == 0 {
+                fmt.Printf("WARNING: the rules have no companions for %s, so the inline policies and policy attachments of %s are NOT protected. Add them under companions in the rules file\n", awsNukeType, callerResource.PhysicalID)
+                continue
+            }
+            fmt.Printf("The companions of %s %s are preserved with it: %s\n", awsNukeType, callerResource.PhysicalID, strings.Join(companionTypes, ", "))
+        }
+    }
+
+    // The instances of the StackSets administered from this account are found by stack ID, in whichever region the StackSet is administered from
+    if stackSetNames := stackSelector.StackSets(); len(stackSetNames) != 0 {
+        fmt.Println("\n\nSTACKSET INSTANCES:")
+        fmt.Println()
+        // A StackSet is administered from a single region, which need not be one of those searched for stacks
+        enabledRegions, err := resources.GetEnabledRegions(logger, apiRegion(regions))
+        if err != nil {
+            discoveryErrors = append(discoveryErrors, resources.DiscoveryError{Region: resources.GlobalRegion, Err: fmt.Errorf("unable to list the regions StackSets may be administered from, so only the regions searched are, %w", err)})
+        }
+        adminRegions := helpers.RemoveDuplicates[string](append(append([]string{}, regions...), enabledRegions...))
+        for _, region := range adminRegions {
+            instances, stackSetErrors := resources.GetStackSetInstances(logger, stackSetNames, accountID, region)
+            discoveryErrors = append(discoveryErrors, stackSetErrors...)
+            for _, instance := range instances {
+                fmt.Printf("%s: %s (StackSet %s)\n", instance.Region, instance.StackID, instance.StackSetName)
+                if helpers.FindItemExact(regions, instance.Region) == -1 {
+                    fmt.Printf("WARNING: %s is not searched, so this instance is NOT protected\n", instance.Region)
+                }
+            }
+            stackSelector.AddStackSetInstances(instances)
+        }
+        if stackOpts.stackSetNameFallback {
+            fmt.Println("StackSets administered from another account, such as service-managed StackSets, are matched by their StackSet-<name>-<uuid> stack names")
+        } else {
+            for _, stackSetName := range stackSelector.StackSetsWithoutInstances() {
+                fmt.Printf("WARNING: no instances of StackSet %s were found with the StackSet APIs, so none are preserved. If it is administered from another account, pass -stackset-name-fallback\n", stackSetName)
+            }
+        }
+    }
 
     fmt.Println("\n\nFinding resources...")
-    for _, region := range aws_regions {
-        // Get all the CFN stacks which match the provided regexes
-        var regionalStackIdsFiltered = resources.GetCFNStacksFromRegex(logger, stacksRegexes, region)
-        stackIdsFiltered = append(stackIdsFiltered, regionalStackIdsFiltered...) 
+    var regionSummaries []resources.RegionSummary
+    for _, region := range regions {
+        summary := resources.RegionSummary{Region: region}
+
+        // Get all the CFN stacks chosen by the stack selectors
+        regionalStacksFiltered, stacksChecked, regionErrors := resources.GetCFNStacks(logger, stackSelector, stackStatuses, region)
+        discoveryErrors = append(discoveryErrors, regionErrors...)
+        stacksFiltered = append(stacksFiltered, regionalStacksFiltered...) 
+        summary.StacksChecked = stacksChecked
+        summary.StacksMatched = len(regionalStacksFiltered)
+
+        // Preserve the stacks which the selected stacks import values from, as the selected stacks would break without them
+        pulledIn := make(map[string]bool)
+        if len(regionalStacksFiltered) != 0 {
+            var preservedStackIds []string
+            for _, stack := range regionalStacksFiltered {
+                preservedStackIds = append(preservedStackIds, stack.ID)
+            }
+            dependencies, dependencyErrors := resources.GetExportProducers(logger, preservedStackIds, region)
+            discoveryErrors = append(discoveryErrors, dependencyErrors...)
+            for _, dependency := range dependencies {
+                fmt.Printf("%s: %s (%s) - %s\n", region, dependency.Stack.Name, dependency.Stack.Status, dependency.Stack.Reason)
+                regionalStacksFiltered = append(regionalStacksFiltered, dependency.Stack)
+                pulledIn[dependency.Stack.ID] = true
+                stacksFiltered = append(stacksFiltered, dependency.Stack)
+            }
+            stackDependencies = append(stackDependencies, dependencies...)
+        }
 
         // Get the child resources for each CFN stack, and group them by resource type (e.g. IAMRole)
-        for _, stackId := range regionalStackIdsFiltered {
-            for resourceType, resources := range resources.GetCFNStackChildren(logger, stackId, region){
-                resourcesToPreserveByType[resourceType] = append(resourcesToPreserveByType[resourceType], resources...)
+        for _, stack := range regionalStacksFiltered {
+            reason := report.ReasonStack
+            if pulledIn[stack.ID] {
+                reason = report.ReasonImport
+            }
+            preservationReport.Stacks = append(preservationReport.Stacks, report.Stack{Region: region, Name: stack.Name, ID: stack.ID, Status: stack.Status, Reason: reason, Detail: stack.Reason})
+            preservationReport.AddResource(report.Resource{Region: region, CFNType: "CloudFormationStack", PhysicalID: stack.Name, Reason: reason, Detail: stack.Reason})
+
+            stackChildren, resourcesChecked, stackErrors := resources.GetCFNStackChildren(logger, stack, region)
+            discoveryErrors = append(discoveryErrors, stackErrors...)
+            for _, child := range stackChildren {
+                resourcesToPreserveByType[child.ResourceType] = append(resourcesToPreserveByType[child.ResourceType], child.PhysicalID)
+                preservationReport.AddResource(report.Resource{
+                    Region:     child.Region,
+                    CFNType:    child.ResourceType,
+                    PhysicalID: child.PhysicalID,
+                    LogicalID:  child.LogicalID,
+                    Chain:      child.Chain,
+                    Reason:     reason,
+                })
             }
+            childrenToPreserve = append(childrenToPreserve, stackChildren...)
+            summary.ResourcesChecked += resourcesChecked
             // Add the stacks themselves to the resources to preserve 
-            resourcesToPreserveByType["CloudFormationStack"] = append(resourcesToPreserveByType["CloudFormationStack"], stackId)
+            resourcesToPreserveByType["CloudFormationStack"] = append(resourcesToPreserveByType["CloudFormationStack"], stack.Name)
         }
+
+        // With -preserve-retained, the resources which outlived their deleted stacks are preserved too
+        if preserveRetained {
+            retainedResources, retainedErrors := resources.GetRetainedResources(logger, stackSelector, region)
+            discoveryErrors = append(discoveryErrors, retainedErrors...)
+            for _, retained := range retainedResources {
+                resourcesToPreserveByType[retained.ResourceType] = append(resourcesToPreserveByType[retained.ResourceType], retained.PhysicalID)
+                preservationReport.AddResource(report.Resource{
+                    Region:     retained.Region,
+                    CFNType:    retained.ResourceType,
+                    PhysicalID: retained.PhysicalID,
+                    LogicalID:  retained.LogicalID,
+                    Chain:      retained.Chain,
+                    Reason:     report.ReasonRetained,
+                })
+            }
+            retainedToPreserve = append(retainedToPreserve, retainedResources...)
+            summary.RetainedResources = len(retainedResources)
+        }
+
+        // With -all-tags, the resources carrying all the tags are preserved individually, like the children of a stack
+        if allTags {
+            taggedResources, taggedErrors := resources.GetResourcesWithAllTags(logger, tagFilters, region)
+            discoveryErrors = append(discoveryErrors, taggedErrors...)
+            for _, tagged := range taggedResources {
+                // Resources whose ARN is not recognised have no CFN type, so are reported as unmapped, by ARN
+                physicalID := tagged.ARN
+                if tagged.CFNType != "" {
+                    resourcesToPreserveByType[tagged.CFNType] = append(resourcesToPreserveByType[tagged.CFNType], tagged.PhysicalID)
+                    physicalID = tagged.PhysicalID
+                }
+                preservationReport.AddResource(report.Resource{Region: tagged.Region, CFNType: tagged.CFNType, PhysicalID: physicalID, Reason: report.ReasonAllTags, Detail: tagged.ARN})
+            }
+            taggedToPreserve = append(taggedToPreserve, taggedResources...)
+            summary.TaggedResources = len(taggedResources)
+        }
+        regionSummaries = append(regionSummaries, summary)
     }
 
-    fmt.Println("\n\nSTACKS MATCHING REGEXES:")
-    for _, stackId := range stackIdsFiltered {
-        fmt.Printf("\n%s", stackId)
+    // Stacks matched by name alone may not belong to the StackSet at all
+    stackSetsByName := stackSelector.StackSetInstancesByName()
+    for _, stackSetName := range helpers.SortedKeys(stackSetsByName) {
+        fmt.Printf("\nWARNING: StackSet %s was resolved by stack name, not with ListStackInstances. Check that these stacks are its instances: %v\n", stackSetName, stackSetsByName[stackSetName])
     }
 
-    logger.Debug(fmt.Sprintf("Stacks to preserve the child resources of: %v\n", stackIdsFiltered))
-    fmt.Println("\n\nRESOURCES TO PRESERVE:")
-    for resourceType, resources := range resourcesToPreserveByType {
-        fmt.Printf("\n%s: %v", resourceType, resources)
+    fmt.Println("\n\nDISCOVERY SUMMARY:")
+    fmt.Println()
+    for _, summary := range regionSummaries {
+        fmt.Printf("%-16s %5d stacks checked, %5d matched, %6d child resources checked", summary.Region, summary.StacksChecked, summary.StacksMatched, summary.ResourcesChecked)
+        if preserveRetained {
+            fmt.Printf(", %5d retained resources", summary.RetainedResources)
+        }
+        if allTags {
+            fmt.Printf(", %5d resources carrying all tags", summary.TaggedResources)
+        }
+        fmt.Println()
     }
 
-    logger.Debug(fmt.Sprintf("Child resources to preserve: %v\n", resourcesToPreserveByType))
+    // Discovery must be complete before anything is deleted, as resources in a region or stack which could not be inspected are not protected
+    if len(discoveryErrors) != 0 {
+        printDiscoveryErrors(discoveryErrors)
+        // A plan is approved as everything the run would do, which it cannot show for the regions and stacks which were not inspected
+        if command == commandPlan {
+            exitWithReport(fmt.Errorf("refusing to save a plan, as discovery is incomplete. Fix the errors above, then run plan again"))
+        }
+        if noDryRun && !allowPartialDiscovery {
+            exitWithReport(fmt.Errorf("refusing to run with -no-dry-run, as discovery is incomplete. Fix the errors above, or pass -allow-partial-discovery to accept that the listed regions and stacks are not protected"))
+        }
+        if noDryRun {
+            fmt.Println("\n-allow-partial-discovery was given, continuing with -no-dry-run regardless")
+        }
+    }
 
-    // Read the provided config file
+    fmt.Println("\n\nSTACKS SELECTED:")
+    for _, stack := range stacksFiltered {
+        fmt.Printf("\n%s (%s)", stack.Name, stack.ID)
+    }
 
-    // Open the file for reading
-    file, err := os.Open(configFile)
-    if err != nil {
-        panic(err)
+    if len(stackDependencies) != 0 {
+        fmt.Println("\n\nWARNING: STACKS PULLED IN BY IMPORTS")
+        fmt.Println()
+        fmt.Println("The following stacks were not selected, but are preserved along with their children, as selected stacks import values from them:")
+        for _, dependency := range stackDependencies {
+            exporter := dependency.Exporter
+            if exporter != dependency.Stack.Name {
+                exporter = fmt.Sprintf("%s (nested in %s)", dependency.Exporter, dependency.Stack.Name)
+            }
+            fmt.Printf("- %s: %s exports %s, imported by %s\n", dependency.Stack.Name, exporter, dependency.ExportName, dependency.ImportedBy)
+        }
     }
-    defer file.Close()
 
-    // Read the current contents of the file into a slice of strings, such that content can be inserted and they can then be written back to the file
-    var lines []string
-    scanner := bufio.NewScanner(file)
-    for scanner.Scan() {
-        lines = append(lines, scanner.Text())
+    logger.Debug(fmt.Sprintf("Stacks to preserve the child resources of: %v\n", stacksFiltered))
+    fmt.Println("\n\nRESOURCES TO PRESERVE:")
+    for _, child := range childrenToPreserve {
+        fmt.Printf("\n%s: %s %s (%s > %s)", child.Region, child.ResourceType, child.PhysicalID, strings.Join(child.Chain, " > "), child.LogicalID)
+    }
+
+    if preserveRetained {
+        fmt.Println("\n\nRETAINED RESOURCES OF DELETED STACKS TO PRESERVE:")
+        fmt.Println("These outlived their deleted stacks. Check that they are still wanted")
+        for _, retained := range retainedToPreserve {
+            fmt.Printf("\n%s: %s %s (RETAINED from deleted %s > %s)", retained.Region, retained.ResourceType, retained.PhysicalID, strings.Join(retained.Chain, " > "), retained.LogicalID)
+        }
     }
 
+    if allTags {
+        fmt.Println("\n\nRESOURCES CARRYING ALL TAGS:")
+        var unrecognised []resources.TaggedResource
+        for _, tagged := range taggedToPreserve {
+            if tagged.CFNType == "" {
+                unrecognised = append(unrecognised, tagged)
+                continue
+            }
+            fmt.Printf("\n%s: %s %s (%s)", tagged.Region, tagged.CFNType, tagged.PhysicalID, tagged.ARN)
+        }
+        if len(unrecognised) != 0 {
+            fmt.Println("\n\nWARNING: the following resources carry all the tags, but Shield does not recognise the type of their ARN. They are NOT protected:")
+            for _, tagged := range unrecognised {
+                fmt.Printf("- %s\n", tagged.ARN)
+            }
+        }
+    }
+
+    logger.Debug(fmt.Sprintf("Child resources to preserve: %v\n", resourcesToPreserveByType))
+
     // Build up the new contents of the config file
 
     // Add the tags to preserve
-    lines = resources.GenerateTagsConfigSection(logger, lines, resourceTags)
+    // With -all-tags, the tagged resources were resolved during discovery, as tag filters would preserve resources carrying any of the tags.
+    // The tag filters are only added for the types the Tagging API cannot resolve, which would otherwise lose their protection
+    if !allTags {
+        addedTo, err := preserveTags(logger, doc, accountID, awsNukeResourceTypes, tagFilters)
+        if err != nil {
+            exitWithReport(err)
+        }
+        for _, filter := range tagFilters {
+            preservationReport.Filters = append(preservationReport.Filters, report.TypeFilter{AwsNukeType: addedTo, Filter: filter, Reason: report.ReasonTag})
+        }
+    } else {
+        uncovered, err := preserveTagsOfUncoveredTypes(logger, doc, accountID, mapper, awsNukeResourceTypes, tagFilters, regions)
+        if err != nil {
+            exitWithReport(err)
+        }
+        for _, awsNukeType := range uncovered {
+            for _, filter := range tagFilters {
+                preservationReport.Filters = append(preservationReport.Filters, report.TypeFilter{AwsNukeType: awsNukeType, Filter: filter, Reason: report.ReasonTag, Detail: "not found by the Tagging API, so preserved when carrying any of the tags"})
+            }
+        }
+    }
     // Add the resource types to preserve
-    lines = GenerateResourceTypeConfigSection(logger, lines, resourceTypesToFilter)
+    var resourceTypesToExclude []string
+    for _, resourceType := range resourceTypesToFilter {
+        if len(resourceType) != 0 {
+            resourceTypesToExclude = append(resourceTypesToExclude, resourceType)
+        }
+    }
+    if err := doc.AddResourceTypeExcludes(resourceTypesToExclude...); err != nil {
+        exitWithReport(err)
+    }
+    preservationReport.ResourceTypes = append(preservationReport.ResourceTypes, resourceTypesToExclude...)
     // Add the individual resources to preserve
-    lines = generateResourceConfigSection(logger, lines, resourcesToPreserveByType)
-    // Add any additional manual filters
-    lines = addAdditionalFilters(logger, lines)
-    // Overwrite the aws-nuke config file with the new contents
-    writeLinesToFile(logger, generatedConfigFile, lines)
+    companions, err := expand.NewEngine(shieldRules.Companions)
+    if err != nil {
+        exitWithReport(err)
+    }
 
-    // Run aws-nuke
+    expectations, err := generateResourceConfigSection(logger, doc, accountID, mapper, registry, companions, mappingOpts, resourcesToPreserveByType)
+    preservationReport.AddExpectations(expectations)
+    if err != nil {
+        exitWithReport(err)
+    }
+    // Add the static filters of the rules
+    if err := addRulesFilters(logger, doc, accountID, shieldRules); err != nil {
+        exitWithReport(err)
+    }
+    for _, awsNukeType := range helpers.SortedKeys(shieldRules.Filters) {
+        for _, filter := range shieldRules.Filters[awsNukeType] {
+            preservationReport.Filters = append(preservationReport.Filters, report.TypeFilter{AwsNukeType: awsNukeType, Filter: filter, Reason: report.ReasonRule})
+        }
+    }
+    // The mapping file is only complete once every type has been mapped, so the inputs are hashed after the filters are generated
+    var inputsHash string
+    if command != "" {
+        inputsHash, err = preservationInputsHash(flag.CommandLine, rulesFiles, mappingFile)
+        if err != nil {
+            exitWithReport(err)
+        }
+    }
+    if command == commandApply && inputsHash != approvedPlan.InputsHash {
+        exitWithReport(fmt.Errorf("%w: the flags, rules files or mapping file have changed since the plan was made", errPlanMismatch))
+    }
+    // Write the generated aws-nuke config file, leaving the base config untouched
+    fmt.Println("\n\nWriting to the config file...")
+    if err := doc.Save(generatedConfigFile); err != nil {
+        exitWithReport(err)
+    }
+
+    // Run aws-nuke in dry run mode first, whatever the flags, and check that every preserved resource is filtered
 
-    fmt.Println("\n\nRUNNING AWS-NUKE")
+    fmt.Println("\n\nRUNNING AWS-NUKE (DRY RUN)")
     fmt.Println()
-    var cmd *exec.Cmd
+    dryRunEvents, nukeErr := runAwsNuke(generatedConfigFile, false, false, nukeOutput)
+    if nukeErr != nil {
+        exitWithReport(fmt.Errorf("%w: the dry run failed, %v", errNukeFailed, nukeErr))
+    }
+    printNukeSummary(dryRunEvents)
+
+    // Tag filters only preserve resources carrying any of the tags when -all-tags is not given
+    var preservedTags []nukeconfig.Filter
+    if !allTags {
+        preservedTags = tagFilters
+    }
+    // Resources of types the generated config excludes or does not target are never reported by the dry run
+    generatedConfig, err := doc.Config()
+    if err != nil {
+        exitWithReport(err)
+    }
+    scans := func(awsNukeType string) bool {
+        return generatedConfig.Scans(accountID, awsNukeType)
+    }
+    interlockReport := interlock.Check(dryRunEvents, expectations, preservedTags, scans)
+    interlockReport.Print(allowNotSeen)
+    preservationReport.AddInterlock(interlockReport, interlockReport.Clean(allowNotSeen))
+    if interlockReport.WouldRemove != 0 || len(interlockReport.TagMisses) != 0 {
+        exitWithReport(fmt.Errorf("%w: aws-nuke would remove resources which Shield preserves. Check the filters of the generated config", errInterlockFailed))
+    }
+    if len(interlockReport.UnknownStates) != 0 {
+        exitWithReport(fmt.Errorf("%w: aws-nuke printed messages Shield does not recognise for %d resources, so whether it would remove them is unknown", errInterlockFailed, len(interlockReport.UnknownStates)))
+    }
+    if !interlockReport.Clean(allowNotSeen) {
+        exitWithReport(fmt.Errorf("%w: the dry run did not report %d preserved resources, so whether they are preserved is unknown. Check them, or pass -allow-not-seen to accept them", errInterlockFailed, interlockReport.NotSeen))
+    }
+    if err := writeReport(preservationReport, discoveryErrors, reportFile, reportMarkdownFile, nil); err != nil {
+        exitWithError(err)
+    }
+
+    if command == commandPlan {
+        awsNukeVersion, err := awsnuke.Version()
+        if err != nil {
+            exitWithError(err)
+        }
+        baseConfigHash, err := plan.HashFile(configFile)
+        if err != nil {
+            exitWithError(err)
+        }
+        newPlan := plan.New(accountID, awsNukeVersion, configFile, baseConfigHash, inputsHash, dryRunEvents)
+        if err := newPlan.Save(planFile, planKey); err != nil {
+            exitWithError(err)
+        }
+        fmt.Printf("\n\nPLAN SAVED to %s: %d resources would be removed from account %s. Review it, then run apply with the same flags\n", planFile, len(newPlan.Deletions), accountID)
+    }
+    // What is removed must be exactly what the reviewer of the plan approved
+    if command == commandApply {
+        if awsNukeVersion, err := awsnuke.Version(); err == nil && awsNukeVersion != approvedPlan.AwsNukeVersion {
+            fmt.Printf("\nWARNING: the plan was made with aws-nuke %s, but %s is installed\n", approvedPlan.AwsNukeVersion, awsNukeVersion)
+        }
+        added, dropped := approvedPlan.CompareDeletions(plan.Deletions(dryRunEvents))
+        if len(added) != 0 || len(dropped) != 0 {
+            printPlanDifferences(added, dropped)
+            exitWithError(fmt.Errorf("%w: the resources aws-nuke would remove have changed since the plan was made. Run plan again", errPlanMismatch))
+        }
+        fmt.Printf("\n\nThe dry run matches the plan: %d resources will be removed\n", len(approvedPlan.Deletions))
+    }
+
     if noDryRun {
-        cmd = exec.Command("bash", "-c", fmt.Sprintf("aws-nuke -c %v --no-dry-run", generatedConfigFile))
-    } else {
-        cmd = exec.Command("bash", "-c", fmt.Sprintf("aws-nuke -c %v", generatedConfigFile))
+        fmt.Println("\n\nRUNNING AWS-NUKE")
+        fmt.Println()
+        var nukeEvents []nukeoutput.Event
+        nukeEvents, nukeErr = runAwsNuke(generatedConfigFile, true, !mappingOpts.nonInteractive, nukeOutput)
+        printNukeSummary(nukeEvents)
+        // aws-nuke does not always exit with an error when it gives up on resources
+        if failed := nukeoutput.Summarise(nukeEvents).States[nukeoutput.StateFailed]; failed != 0 && nukeErr == nil {
+            nukeErr = fmt.Errorf("%d resources could not be removed", failed)
+        }
     }
-    cmd.Stdin = os.Stdin
-    cmd.Stdout = os.Stdout
-    cmd.Stderr = os.Stderr
-    _ = cmd.Run()
+
+    if len(discoveryErrors) != 0 {
+        printDiscoveryErrors(discoveryErrors)
+    }
+    if nukeErr != nil {
+        exitWithError(fmt.Errorf("%w: %v", errNukeFailed, nukeErr))
+    }
+    // os.Exit skips the deferred sync
+    logger.Sync()
+    if noDryRun {
+        os.Exit(exitNukeComplete)
+    }
+    os.Exit(exitConfigGenerated)
 }
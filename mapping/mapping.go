@@ -0,0 +1,160 @@
+package mapping
+
+import (
+	"awsnukeshield/helpers"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Where a mapping came from
+const (
+    SourceUser    = "mapping file"
+    SourceCatalog = "catalog"
+)
+
+// The default location of the user's mapping file
+const DefaultMappingFile = "shield-mappings.yml"
+
+//go:embed catalog.yml
+var catalogYAML []byte
+
+// The format of both the bundled catalog and the user's mapping file. An empty aws-nuke type records that the CFN type should not be mapped
+type mappingFile struct {
+    Mappings map[string]string `yaml:"mappings"`
+}
+
+// A single row of the effective mapping table
+type Entry struct {
+    CFNType     string
+    AwsNukeType string
+    Source      string
+}
+
+// Maps CloudFormation resource types to aws-nuke resource types. Mappings in the user's mapping file take precedence over the bundled catalog
+type Mapper struct {
+    catalog   map[string]string
+    user      map[string]string
+    userFile  string
+    nukeTypes []string
+    changed   bool
+}
+
+// Create a mapper from the bundled catalog and the user's mapping file at userFile, which need not exist yet.
+// nukeTypes are the resource types supported by the installed aws-nuke; catalog entries for other types are ignored
+func NewMapper(userFile string, nukeTypes []string) (*Mapper, error) {
+    var catalog mappingFile
+    if err := yaml.Unmarshal(catalogYAML, &catalog); err != nil {
+        return nil, fmt.Errorf("unable to parse the bundled mapping catalog: %w", err)
+    }
+
+    var user mappingFile
+    data, err := os.ReadFile(userFile)
+    if err != nil && !errors.Is(err, fs.ErrNotExist) {
+        return nil, err
+    }
+    if err := yaml.Unmarshal(data, &user); err != nil {
+        return nil, fmt.Errorf("unable to parse %s: %w", userFile, err)
+    }
+    if user.Mappings == nil {
+        user.Mappings = make(map[string]string)
+    }
+
+    return &Mapper{
+        catalog:   catalog.Mappings,
+        user:      user.Mappings,
+        userFile:  userFile,
+        nukeTypes: nukeTypes,
+    }, nil
+}
+
+// Return the aws-nuke type for cfnType from the mapping file or catalog, and where it came from. found is false if neither knows the type.
+// An empty awsNukeType with found set means the user chose not to map the type
+func (m *Mapper) Lookup(cfnType string) (awsNukeType string, source string, found bool) {
+    if awsNukeType, ok := m.user[cfnType]; ok {
+        return awsNukeType, SourceUser, true
+    }
+    if awsNukeType, ok := m.catalog[cfnType]; ok && m.Supported(awsNukeType) {
+        return awsNukeType, SourceCatalog, true
+    }
+    return "", "", false
+}
+
+// Guess the aws-nuke type for a CFN type which has no mapping, by concatenating the segments of the CFN type and searching the aws-nuke types for them.
+// Returns the exact match if there is one, else all the partial matches
+func (m *Mapper) Guess(cfnType string) (string, []string) {
+    var allPossibleMatches []string
+
+    cleanedKey := strings.Replace(cfnType, "AWS::", "", -1)
+    awsNukeKeySplit := strings.Split(cleanedKey, "::")
+    for i := 0; i < len(awsNukeKeySplit); i++ {
+        stringToSearch := strings.Join(awsNukeKeySplit[i:], "")
+
+        possibleMatches := helpers.FindItemAll(m.nukeTypes, stringToSearch)
+        findIndex := helpers.FindItemExact(possibleMatches, stringToSearch)
+        if findIndex != -1 {
+            return possibleMatches[findIndex], nil
+        }
+        allPossibleMatches = append(allPossibleMatches, possibleMatches...)
+    }
+
+    return "", helpers.RemoveDuplicates[string](allPossibleMatches)
+}
+
+// Record the user's choice for cfnType, to be written to the mapping file by Save. An empty awsNukeType records that the type should not be mapped
+func (m *Mapper) Remember(cfnType string, awsNukeType string) {
+    m.user[cfnType] = awsNukeType
+    m.changed = true
+}
+
+// Write the mapping file if any choices were remembered. Returns whether the file was written
+func (m *Mapper) Save() (bool, error) {
+    if !m.changed {
+        return false, nil
+    }
+
+    data, err := yaml.Marshal(mappingFile{Mappings: m.user})
+    if err != nil {
+        return false, err
+    }
+    header := "# CloudFormation to aws-nuke resource type mappings chosen when running Shield. These take precedence over the bundled catalog.\n" +
+        "# An empty value means resources of that CloudFormation type are not added to the config file.\n"
+    if err := os.WriteFile(m.userFile, append([]byte(header), data...), 0644); err != nil {
+        return false, err
+    }
+    m.changed = false
+    return true, nil
+}
+
+// Return the effective mapping table, sorted by CFN type
+func (m *Mapper) Table() []Entry {
+    var entries []Entry
+    for cfnType := range m.catalog {
+        if _, overridden := m.user[cfnType]; !overridden {
+            entries = append(entries, Entry{CFNType: cfnType, AwsNukeType: m.catalog[cfnType], Source: SourceCatalog})
+        }
+    }
+    for cfnType, awsNukeType := range m.user {
+        entries = append(entries, Entry{CFNType: cfnType, AwsNukeType: awsNukeType, Source: SourceUser})
+    }
+    sort.Slice(entries, func(i, j int) bool {
+        return entries[i].CFNType < entries[j].CFNType
+    })
+    return entries
+}
+
+// Whether the installed aws-nuke supports awsNukeType. If the supported types are unknown, everything is assumed supported
+func (m *Mapper) Supported(awsNukeType string) bool {
+    return len(m.nukeTypes) == 0 || helpers.FindItemExact(m.nukeTypes, awsNukeType) != -1
+}
+
+// The path of the user's mapping file
+func (m *Mapper) UserFile() string {
+    return m.userFile
+}
@@ -0,0 +1,143 @@
+package mapping
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+    userFile := filepath.Join(t.TempDir(), "shield-mappings.yml")
+    mappings := "mappings:\n  AWS::S3::Bucket: S3BucketCustom\n  AWS::Custom::Thing: CustomThing\n  AWS::SQS::Queue: \"\"\n"
+    if err := os.WriteFile(userFile, []byte(mappings), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name        string
+        nukeTypes   []string
+        cfnType     string
+        awsNukeType string
+        source      string
+        found       bool
+    }{
+        {name: "catalog", cfnType: "AWS::IAM::Role", awsNukeType: "IAMRole", source: SourceCatalog, found: true},
+        {name: "mapping file overrides the catalog", cfnType: "AWS::S3::Bucket", awsNukeType: "S3BucketCustom", source: SourceUser, found: true},
+        {name: "mapping file only", cfnType: "AWS::Custom::Thing", awsNukeType: "CustomThing", source: SourceUser, found: true},
+        {name: "chosen not to be mapped", cfnType: "AWS::SQS::Queue", awsNukeType: "", source: SourceUser, found: true},
+        {name: "unknown", cfnType: "AWS::Nothing::Here"},
+        {name: "catalog type supported by aws-nuke", nukeTypes: []string{"EC2VPC", "IAMRole"}, cfnType: "AWS::IAM::Role", awsNukeType: "IAMRole", source: SourceCatalog, found: true},
+        {name: "catalog type not supported by aws-nuke", nukeTypes: []string{"EC2VPC"}, cfnType: "AWS::IAM::Role"},
+        {name: "mapping file type not supported by aws-nuke", nukeTypes: []string{"EC2VPC"}, cfnType: "AWS::Custom::Thing", awsNukeType: "CustomThing", source: SourceUser, found: true},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            mapper, err := NewMapper(userFile, test.nukeTypes)
+            if err != nil {
+                t.Fatal(err)
+            }
+            awsNukeType, source, found := mapper.Lookup(test.cfnType)
+            if awsNukeType != test.awsNukeType || source != test.source || found != test.found {
+                t.Errorf("Lookup(%q) = %q, %q, %v, want %q, %q, %v", test.cfnType, awsNukeType, source, found, test.awsNukeType, test.source, test.found)
+            }
+        })
+    }
+}
+
+func TestRemember(t *testing.T) {
+    tests := []struct {
+        name        string
+        existing    string
+        cfnType     string
+        awsNukeType string
+        saved       map[string]string
+    }{
+        {name: "new mapping file", cfnType: "AWS::Custom::Thing", awsNukeType: "CustomThing", saved: map[string]string{"AWS::Custom::Thing": "CustomThing"}},
+        {
+            name: "added to the mapping file", existing: "mappings:\n  AWS::Other::Thing: OtherThing\n", cfnType: "AWS::Custom::Thing", awsNukeType: "CustomThing",
+            saved: map[string]string{"AWS::Other::Thing": "OtherThing", "AWS::Custom::Thing": "CustomThing"},
+        },
+        {name: "overrides the catalog", cfnType: "AWS::S3::Bucket", awsNukeType: "S3BucketCustom", saved: map[string]string{"AWS::S3::Bucket": "S3BucketCustom"}},
+        {
+            name: "replaces a mapping", existing: "mappings:\n  AWS::Custom::Thing: OldThing\n", cfnType: "AWS::Custom::Thing", awsNukeType: "CustomThing",
+            saved: map[string]string{"AWS::Custom::Thing": "CustomThing"},
+        },
+        {name: "chosen not to be mapped", cfnType: "AWS::IAM::Role", awsNukeType: "", saved: map[string]string{"AWS::IAM::Role": ""}},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            userFile := filepath.Join(t.TempDir(), "shield-mappings.yml")
+            if test.existing != "" {
+                if err := os.WriteFile(userFile, []byte(test.existing), 0644); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            mapper, err := NewMapper(userFile, nil)
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            mapper.Remember(test.cfnType, test.awsNukeType)
+            if awsNukeType, source, found := mapper.Lookup(test.cfnType); awsNukeType != test.awsNukeType || source != SourceUser || !found {
+                t.Errorf("Lookup(%q) after Remember = %q, %q, %v, want %q, %q, true", test.cfnType, awsNukeType, source, found, test.awsNukeType, SourceUser)
+            }
+            if saved, err := mapper.Save(); err != nil || !saved {
+                t.Fatalf("Save() = %v, %v, want true", saved, err)
+            }
+            if saved, err := mapper.Save(); err != nil || saved {
+                t.Errorf("Save() without changes = %v, %v, want false", saved, err)
+            }
+
+            // The choice is read back from the mapping file, along with what was there before
+            reloaded, err := NewMapper(userFile, nil)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !reflect.DeepEqual(reloaded.user, test.saved) {
+                t.Errorf("mapping file holds %v, want %v", reloaded.user, test.saved)
+            }
+        })
+    }
+}
+
+func TestSaveWithoutChoices(t *testing.T) {
+    userFile := filepath.Join(t.TempDir(), "shield-mappings.yml")
+    mapper, err := NewMapper(userFile, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if saved, err := mapper.Save(); err != nil || saved {
+        t.Errorf("Save() = %v, %v, want false", saved, err)
+    }
+    if _, err := os.Stat(userFile); !os.IsNotExist(err) {
+        t.Errorf("the mapping file was written without any choice remembered")
+    }
+}
+
+func TestTable(t *testing.T) {
+    userFile := filepath.Join(t.TempDir(), "shield-mappings.yml")
+    mapper, err := NewMapper(userFile, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    mapper.Remember("AWS::S3::Bucket", "S3BucketCustom")
+
+    var previous string
+    sources := make(map[string]string)
+    for _, entry := range mapper.Table() {
+        if entry.CFNType <= previous {
+            t.Errorf("%s is listed after %s", entry.CFNType, previous)
+        }
+        previous = entry.CFNType
+        sources[entry.CFNType] = entry.Source + " " + entry.AwsNukeType
+    }
+    if sources["AWS::S3::Bucket"] != SourceUser+" S3BucketCustom" {
+        t.Errorf("AWS::S3::Bucket is listed as %q, want the remembered mapping", sources["AWS::S3::Bucket"])
+    }
+    if sources["AWS::IAM::Role"] != SourceCatalog+" IAMRole" {
+        t.Errorf("AWS::IAM::Role is listed as %q, want the catalog mapping", sources["AWS::IAM::Role"])
+    }
+}
@@ -0,0 +1,454 @@
+package nukeconfig
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Filter types accepted by aws-nuke. An empty type is treated by aws-nuke as exact
+const (
+    FilterTypeExact    = "exact"
+    FilterTypeGlob     = "glob"
+    FilterTypeRegex    = "regex"
+    FilterTypeContains = "contains"
+)
+
+// The filters key whose filters aws-nuke applies to resources of every type
+const GlobalFilterKey = "__global__"
+
+// A single aws-nuke filter. A filter with only a Value is written in the short form, which aws-nuke compares against the resource ID
+type Filter struct {
+    Property string `yaml:"property,omitempty" json:"property,omitempty"`
+    Type     string `yaml:"type,omitempty" json:"type,omitempty"`
+    Value    string `yaml:"value" json:"value"`
+    Invert   string `yaml:"invert,omitempty" json:"invert,omitempty"`
+}
+
+// Accept both the short (plain string) and the long (mapping) form of a filter
+func (f *Filter) UnmarshalYAML(node *yaml.Node) error {
+    if node.Kind == yaml.ScalarNode {
+        *f = Filter{Value: node.Value}
+        return nil
+    }
+    type plain Filter
+    return node.Decode((*plain)(f))
+}
+
+// Write the filter in the short form whenever it only carries a value. invert is written as a bool, and only when the filter is inverted
+func (f Filter) MarshalYAML() (interface{}, error) {
+    inverted := f.Invert == "true"
+    if f.Property == "" && f.Type == "" && !inverted {
+        return f.Value, nil
+    }
+    return struct {
+        Property string `yaml:"property,omitempty"`
+        Type     string `yaml:"type,omitempty"`
+        Value    string `yaml:"value"`
+        Invert   bool   `yaml:"invert,omitempty"`
+    }{f.Property, f.Type, f.Value, inverted}, nil
+}
+
+// Whether the filters are the same to aws-nuke: exact is the default type, and a filter is only inverted by "true"
+func (f Filter) Equivalent(other Filter) bool {
+    return f.normalised() == other.normalised()
+}
+
+func (f Filter) normalised() Filter {
+    if f.Type == "" {
+        f.Type = FilterTypeExact
+    }
+    if f.Invert != "true" {
+        f.Invert = ""
+    }
+    return f
+}
+
+// Describe the filter for display, e.g. role:RoleName exact "my-role" (inverted)
+func (f Filter) Describe() string {
+    f = f.normalised()
+    property := f.Property
+    if property == "" {
+        property = "(resource ID)"
+    }
+    description := fmt.Sprintf("%s %s %q", property, f.Type, f.Value)
+    if f.Invert == "true" {
+        description += " (inverted)"
+    }
+    return description
+}
+
+// Sort filters by property, type and value, so that they are written in the same order on every run
+func SortFilters(filters []Filter) {
+    sort.SliceStable(filters, func(i, j int) bool {
+        return filters[i].less(filters[j])
+    })
+}
+
+func (f Filter) less(other Filter) bool {
+    a, b := f.normalised(), other.normalised()
+    if a.Property != b.Property {
+        return a.Property < b.Property
+    }
+    if a.Type != b.Type {
+        return a.Type < b.Type
+    }
+    if a.Value != b.Value {
+        return a.Value < b.Value
+    }
+    return a.Invert < b.Invert
+}
+
+// Whether the filter matches the given value, the way aws-nuke compares it: the resource ID for filters without a property, else the property value.
+// An inverted filter matches values which the filter otherwise would not
+func (f Filter) Match(value string) (bool, error) {
+    var matched bool
+    switch f.Type {
+    case "", FilterTypeExact:
+        matched = value == f.Value
+    case FilterTypeContains:
+        matched = strings.Contains(value, f.Value)
+    case FilterTypeGlob:
+        re, err := regexp.Compile("^" + GlobPattern(f.Value) + "$")
+        if err != nil {
+            return false, err
+        }
+        matched = re.MatchString(value)
+    case FilterTypeRegex:
+        re, err := regexp.Compile(f.Value)
+        if err != nil {
+            return false, err
+        }
+        matched = re.MatchString(value)
+    default:
+        return false, fmt.Errorf("unknown filter type %q", f.Type)
+    }
+
+    if f.Invert == "true" {
+        return !matched, nil
+    }
+    return matched, nil
+}
+
+// Return the unanchored regex equivalent of an aws-nuke glob, in which * matches any characters and ? a single character
+func GlobPattern(glob string) string {
+    var pattern strings.Builder
+    for _, char := range glob {
+        switch char {
+        case '*':
+            pattern.WriteString(".*")
+        case '?':
+            pattern.WriteString(".")
+        default:
+            pattern.WriteString(regexp.QuoteMeta(string(char)))
+        }
+    }
+    return pattern.String()
+}
+
+type ResourceTypes struct {
+    Targets  []string `yaml:"targets,omitempty"`
+    // The aws-nuke v3 name for targets
+    Includes []string `yaml:"includes,omitempty"`
+    Excludes []string `yaml:"excludes,omitempty"`
+}
+
+type Account struct {
+    Filters       map[string][]Filter `yaml:"filters,omitempty"`
+    ResourceTypes ResourceTypes       `yaml:"resource-types,omitempty"`
+    Presets       []string            `yaml:"presets,omitempty"`
+}
+
+// Typed view of the parts of the aws-nuke config file which Shield reads
+type Config struct {
+    Regions          []string           `yaml:"regions"`
+    AccountBlocklist []string           `yaml:"account-blocklist"`
+    ResourceTypes    ResourceTypes      `yaml:"resource-types"`
+    Accounts         map[string]Account `yaml:"accounts"`
+}
+
+// Whether aws-nuke scans resourceType in account: it is targeted, or nothing is, and it is not excluded, at the top level and for the account
+func (c *Config) Scans(account string, resourceType string) bool {
+    for _, resourceTypes := range []ResourceTypes{c.ResourceTypes, c.Accounts[account].ResourceTypes} {
+        targets := append(append([]string{}, resourceTypes.Targets...), resourceTypes.Includes...)
+        if len(targets) != 0 && !slices.Contains(targets, resourceType) {
+            return false
+        }
+        if slices.Contains(resourceTypes.Excludes, resourceType) {
+            return false
+        }
+    }
+    return true
+}
+
+// An aws-nuke config file held as a YAML node tree, so that content (including comments) which Shield does not touch is written back out unchanged
+type Document struct {
+    root *yaml.Node
+    // The length of the content of each node Shield added to, before it did. What Shield adds after it is kept sorted,
+    // so that the generated config does not depend on the order in which the filters were added
+    base map[*yaml.Node]int
+}
+
+// Read and parse the aws-nuke config file at path
+func Load(path string) (*Document, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    doc, err := Parse(data)
+    if err != nil {
+        return nil, fmt.Errorf("unable to parse %s: %w", path, err)
+    }
+    return doc, nil
+}
+
+// Parse the contents of an aws-nuke config file
+func Parse(data []byte) (*Document, error) {
+    var root yaml.Node
+    if err := yaml.Unmarshal(data, &root); err != nil {
+        return nil, err
+    }
+
+    if root.Kind == 0 {
+        // Empty file
+        root = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{newMapping()}}
+    }
+    if root.Kind != yaml.DocumentNode || len(root.Content) != 1 || root.Content[0].Kind != yaml.MappingNode {
+        return nil, fmt.Errorf("the top level of an aws-nuke config must be a mapping")
+    }
+
+    return &Document{root: &root, base: make(map[*yaml.Node]int)}, nil
+}
+
+// Decode the document into the typed config model
+func (d *Document) Config() (*Config, error) {
+    var cfg Config
+    if err := d.root.Decode(&cfg); err != nil {
+        return nil, err
+    }
+    return &cfg, nil
+}
+
+// Append filters for resourceType under the filters of the given account, skipping any equivalent to a filter already present. The filters
+// and resource type keys Shield adds are kept sorted after those of the base config, whatever order they are added in.
+// The account must already be present in the config, as aws-nuke refuses to run against accounts which are not listed; the filters block
+// and resource type key are created if missing
+func (d *Document) AddFilters(account string, resourceType string, filters ...Filter) error {
+    if len(filters) == 0 {
+        return nil
+    }
+
+    accounts := mappingValue(d.top(), "accounts")
+    if accounts == nil || accounts.Kind != yaml.MappingNode {
+        return fmt.Errorf("the config has no accounts section")
+    }
+    accountNode := mappingValue(accounts, account)
+    if accountNode == nil {
+        return fmt.Errorf("account %s is not listed in the accounts section of the config", account)
+    }
+    accountNode, err := ensureMapping(accounts, account)
+    if err != nil {
+        return err
+    }
+    filtersNode, err := ensureMapping(accountNode, "filters")
+    if err != nil {
+        return fmt.Errorf("account %s: %w", account, err)
+    }
+    filtersBase := d.baseLength(filtersNode)
+    typeNode, err := ensureSequence(filtersNode, resourceType)
+    if err != nil {
+        return fmt.Errorf("account %s: %w", account, err)
+    }
+    typeBase := d.baseLength(typeNode)
+
+    var present []Filter
+    for _, node := range typeNode.Content {
+        // Filters aws-nuke accepts but Shield cannot read are left as they are, at worst leading to a duplicate
+        var existing Filter
+        if err := node.Decode(&existing); err == nil {
+            present = append(present, existing)
+        }
+    }
+
+    sorted := append([]Filter{}, filters...)
+    SortFilters(sorted)
+    for _, filter := range sorted {
+        if containsEquivalent(present, filter) {
+            continue
+        }
+        var node yaml.Node
+        if err := node.Encode(filter); err != nil {
+            return err
+        }
+        typeNode.Content = append(typeNode.Content, &node)
+        present = append(present, filter)
+    }
+
+    added := typeNode.Content[typeBase:]
+    sort.SliceStable(added, func(i, j int) bool {
+        var a, b Filter
+        added[i].Decode(&a)
+        added[j].Decode(&b)
+        return a.less(b)
+    })
+    sortMappingKeys(filtersNode, filtersBase)
+    return nil
+}
+
+func containsEquivalent(filters []Filter, filter Filter) bool {
+    for _, existing := range filters {
+        if existing.Equivalent(filter) {
+            return true
+        }
+    }
+    return false
+}
+
+// Append resource types to the top level resource-types excludes list, so that aws-nuke removes no resources of these types. Types already excluded are skipped,
+// and those added are kept sorted after those of the base config
+func (d *Document) AddResourceTypeExcludes(resourceTypes ...string) error {
+    if len(resourceTypes) == 0 {
+        return nil
+    }
+
+    resourceTypesNode, err := ensureMapping(d.top(), "resource-types")
+    if err != nil {
+        return err
+    }
+    excludes, err := ensureSequence(resourceTypesNode, "excludes")
+    if err != nil {
+        return err
+    }
+    excludesBase := d.baseLength(excludes)
+    for _, resourceType := range resourceTypes {
+        excluded := false
+        for _, node := range excludes.Content {
+            if node.Kind == yaml.ScalarNode && node.Value == resourceType {
+                excluded = true
+                break
+            }
+        }
+        if !excluded {
+            excludes.Content = append(excludes.Content, newScalar(resourceType))
+        }
+    }
+
+    added := excludes.Content[excludesBase:]
+    sort.SliceStable(added, func(i, j int) bool {
+        return added[i].Value < added[j].Value
+    })
+    return nil
+}
+
+// Marshal the document back to YAML
+func (d *Document) Bytes() ([]byte, error) {
+    var buf bytes.Buffer
+    encoder := yaml.NewEncoder(&buf)
+    encoder.SetIndent(2)
+    if err := encoder.Encode(d.root); err != nil {
+        return nil, err
+    }
+    if err := encoder.Close(); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+// Overwrite the file at path with the marshalled document
+func (d *Document) Save(path string) error {
+    data, err := d.Bytes()
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(path, data, 0644)
+}
+
+// The top level mapping of the document
+func (d *Document) top() *yaml.Node {
+    return d.root.Content[0]
+}
+
+// Return the length of the content of node before Shield first added to it, recording it on the first call
+func (d *Document) baseLength(node *yaml.Node) int {
+    if length, ok := d.base[node]; ok {
+        return length
+    }
+    d.base[node] = len(node.Content)
+    return len(node.Content)
+}
+
+// Sort the keys of the mapping node m from the given content index on, keeping each value with its key
+func sortMappingKeys(m *yaml.Node, from int) {
+    var pairs [][2]*yaml.Node
+    for i := from; i+1 < len(m.Content); i += 2 {
+        pairs = append(pairs, [2]*yaml.Node{m.Content[i], m.Content[i+1]})
+    }
+    sort.SliceStable(pairs, func(i, j int) bool {
+        return pairs[i][0].Value < pairs[j][0].Value
+    })
+    for i, pair := range pairs {
+        m.Content[from+2*i] = pair[0]
+        m.Content[from+2*i+1] = pair[1]
+    }
+}
+
+// Return the value node stored under key in the mapping node m, or nil if the key is not present
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+    for i := 0; i+1 < len(m.Content); i += 2 {
+        if m.Content[i].Value == key {
+            return m.Content[i+1]
+        }
+    }
+    return nil
+}
+
+// Return the mapping stored under key in m, creating it if the key is missing or holds an empty value (e.g. "filters:" with nothing below it)
+func ensureMapping(m *yaml.Node, key string) (*yaml.Node, error) {
+    return ensureKind(m, key, yaml.MappingNode)
+}
+
+// Return the sequence stored under key in m, creating it if the key is missing or holds an empty value
+func ensureSequence(m *yaml.Node, key string) (*yaml.Node, error) {
+    return ensureKind(m, key, yaml.SequenceNode)
+}
+
+func ensureKind(m *yaml.Node, key string, kind yaml.Kind) (*yaml.Node, error) {
+    value := mappingValue(m, key)
+    if value == nil {
+        value = &yaml.Node{}
+        m.Content = append(m.Content, newScalar(key), value)
+    } else if value.Kind == kind {
+        return value, nil
+    } else if !isNull(value) {
+        return nil, fmt.Errorf("%s has an unexpected value on line %d", key, value.Line)
+    }
+
+    // Convert the empty value in place, so that any comments attached to it are kept
+    value.Kind = kind
+    value.Style = 0
+    value.Value = ""
+    if kind == yaml.MappingNode {
+        value.Tag = "!!map"
+    } else {
+        value.Tag = "!!seq"
+    }
+    return value, nil
+}
+
+func isNull(node *yaml.Node) bool {
+    return node.Kind == 0 || (node.Kind == yaml.ScalarNode && (node.Tag == "!!null" || node.Value == ""))
+}
+
+func newMapping() *yaml.Node {
+    return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+}
+
+func newScalar(value string) *yaml.Node {
+    return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
@@ -0,0 +1,268 @@
+package nukeconfig
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+const baseConfig = `regions:
+  - eu-west-1
+account-blocklist:
+  - "999999999999"
+accounts:
+  "123456789012":
+    filters:
+      # Kept by hand
+      IAMRole:
+        - OrganizationAccountAccessRole
+        - type: exact
+          value: app-Role
+      S3Bucket:
+        - property: Name
+          type: exact
+          value: app-logs
+resource-types:
+  excludes:
+    - Route53HostedZone
+`
+
+type addition struct {
+    resourceType string
+    filters      []Filter
+}
+
+// What Shield adds to the base config, including filters equivalent to ones already in it
+var additions = []addition{
+    {"IAMRole", []Filter{{Value: "app-Role"}, {Value: "other-Role"}, {Type: FilterTypeGlob, Value: "app-*"}}},
+    {"IAMRole", []Filter{{Type: FilterTypeExact, Value: "OrganizationAccountAccessRole"}, {Value: "another-Role"}}},
+    {"S3Bucket", []Filter{{Property: "Name", Value: "app-logs"}, {Property: "Name", Value: "app-data"}}},
+    {"SNSTopic", []Filter{{Property: "TopicARN", Value: "arn:aws:sns:eu-west-1:123456789012:alerts"}}},
+    {"ECSCluster", []Filter{{Type: FilterTypeGlob, Value: "arn:aws*:ecs:*:*:cluster/app"}}},
+    {GlobalFilterKey, []Filter{{Property: "tag:env", Value: "prod"}, {Property: "tag:team", Type: FilterTypeRegex, Value: ".+"}}},
+    {"EC2Instance", []Filter{{Property: "tag:env", Value: "prod", Invert: "false"}, {Value: "i-0a1b2c3d"}}},
+}
+
+var excludes = []string{"GuardDutyDetector", "CloudTrailTrail", "Route53HostedZone", "SecurityHub"}
+
+// Generate the config from the base config, adding everything in an order given by seed
+func generate(t *testing.T, seed int64) []byte {
+    doc, err := Parse([]byte(baseConfig))
+    if err != nil {
+        t.Fatal(err)
+    }
+    random := rand.New(rand.NewSource(seed))
+
+    shuffled := append([]addition{}, additions...)
+    random.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+    for _, addition := range shuffled {
+        filters := append([]Filter{}, addition.filters...)
+        random.Shuffle(len(filters), func(i, j int) { filters[i], filters[j] = filters[j], filters[i] })
+        if err := doc.AddFilters("123456789012", addition.resourceType, filters...); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    resourceTypes := append([]string{}, excludes...)
+    random.Shuffle(len(resourceTypes), func(i, j int) { resourceTypes[i], resourceTypes[j] = resourceTypes[j], resourceTypes[i] })
+    for _, resourceType := range resourceTypes {
+        if err := doc.AddResourceTypeExcludes(resourceType); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    data, err := doc.Bytes()
+    if err != nil {
+        t.Fatal(err)
+    }
+    return data
+}
+
+func TestGeneratedConfigIsReproducible(t *testing.T) {
+    first := generate(t, 1)
+    for seed := int64(2); seed <= 20; seed++ {
+        if data := generate(t, seed); !bytes.Equal(data, first) {
+            t.Fatalf("the config generated with seed %d differs from the one generated with seed 1:\n%s\n---\n%s", seed, data, first)
+        }
+    }
+
+    want := `regions:
+  - eu-west-1
+account-blocklist:
+  - "999999999999"
+accounts:
+  "123456789012":
+    filters:
+      # Kept by hand
+      IAMRole:
+        - OrganizationAccountAccessRole
+        - type: exact
+          value: app-Role
+        - another-Role
+        - other-Role
+        - type: glob
+          value: app-*
+      S3Bucket:
+        - property: Name
+          type: exact
+          value: app-logs
+        - property: Name
+          value: app-data
+      EC2Instance:
+        - i-0a1b2c3d
+        - property: tag:env
+          value: prod
+      ECSCluster:
+        - type: glob
+          value: arn:aws*:ecs:*:*:cluster/app
+      SNSTopic:
+        - property: TopicARN
+          value: arn:aws:sns:eu-west-1:123456789012:alerts
+      __global__:
+        - property: tag:env
+          value: prod
+        - property: tag:team
+          type: regex
+          value: .+
+resource-types:
+  excludes:
+    - Route53HostedZone
+    - CloudTrailTrail
+    - GuardDutyDetector
+    - SecurityHub
+`
+    if string(first) != want {
+        t.Errorf("generated config:\n%s\nwant:\n%s", first, want)
+    }
+}
+
+func TestAddFiltersSkipsEquivalents(t *testing.T) {
+    doc, err := Parse([]byte(baseConfig))
+    if err != nil {
+        t.Fatal(err)
+    }
+    equivalents := []Filter{{Type: FilterTypeExact, Value: "OrganizationAccountAccessRole"}, {Value: "app-Role"}, {Value: "app-Role", Invert: "false"}}
+    if err := doc.AddFilters("123456789012", "IAMRole", equivalents...); err != nil {
+        t.Fatal(err)
+    }
+    data, err := doc.Bytes()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if strings.Count(string(data), "OrganizationAccountAccessRole") != 1 || strings.Count(string(data), "app-Role") != 1 {
+        t.Errorf("equivalent filters were added again:\n%s", data)
+    }
+
+    if err := doc.AddFilters("210987654321", "IAMRole", Filter{Value: "app-Role"}); err == nil {
+        t.Error("AddFilters accepted an account which is not in the config")
+    }
+}
+
+func TestScans(t *testing.T) {
+    doc, err := Parse([]byte(`resource-types:
+  targets:
+    - S3Bucket
+    - IAMRole
+    - EC2Instance
+  excludes:
+    - EC2Instance
+accounts:
+  "123456789012":
+    resource-types:
+      excludes:
+        - IAMRole
+  "210987654321":
+    resource-types:
+      includes:
+        - IAMRole
+`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    cfg, err := doc.Config()
+    if err != nil {
+        t.Fatal(err)
+    }
+    tests := []struct {
+        account      string
+        resourceType string
+        scans        bool
+    }{
+        {"123456789012", "S3Bucket", true},
+        // Excluded for the account
+        {"123456789012", "IAMRole", false},
+        // Excluded at the top level
+        {"123456789012", "EC2Instance", false},
+        // Not targeted at the top level
+        {"123456789012", "SNSTopic", false},
+        // Not included for the account
+        {"210987654321", "S3Bucket", false},
+        {"210987654321", "IAMRole", true},
+        // An account without resource types of its own
+        {"999999999999", "S3Bucket", true},
+    }
+    for _, test := range tests {
+        if got := cfg.Scans(test.account, test.resourceType); got != test.scans {
+            t.Errorf("Scans(%s, %s) = %v, want %v", test.account, test.resourceType, got, test.scans)
+        }
+    }
+}
+
+func TestDescribe(t *testing.T) {
+    tests := []struct {
+        filter Filter
+        want   string
+    }{
+        {Filter{Value: "app-Role"}, `(resource ID) exact "app-Role"`},
+        {Filter{Property: "role:RoleName", Type: FilterTypeGlob, Value: "app-*"}, `role:RoleName glob "app-*"`},
+        {Filter{Property: "tag:Env", Value: "prod", Invert: "true"}, `tag:Env exact "prod" (inverted)`},
+        // Only "true" inverts a filter
+        {Filter{Property: "tag:Env", Value: "prod", Invert: "false"}, `tag:Env exact "prod"`},
+    }
+    for _, test := range tests {
+        if got := test.filter.Describe(); got != test.want {
+            t.Errorf("Describe() = %s, want %s", got, test.want)
+        }
+    }
+}
+
+func TestInvertIsWrittenAsBool(t *testing.T) {
+    doc, err := Parse([]byte(baseConfig))
+    if err != nil {
+        t.Fatal(err)
+    }
+    filters := []Filter{{Property: "tag:Env", Value: "prod", Invert: "true"}, {Value: "i-0a1b2c3d", Invert: "false"}}
+    if err := doc.AddFilters("123456789012", "EC2Instance", filters...); err != nil {
+        t.Fatal(err)
+    }
+    data, err := doc.Bytes()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !strings.Contains(string(data), "invert: true\n") || strings.Contains(string(data), `"true"`) || strings.Contains(string(data), "false") {
+        t.Errorf("invert is not written as a bool, or only when true:\n%s", data)
+    }
+    if !strings.Contains(string(data), "- i-0a1b2c3d\n") {
+        t.Errorf("a filter which is not inverted is not written in the short form:\n%s", data)
+    }
+
+    // The written config reads back to the same filters
+    written, err := Parse(data)
+    if err != nil {
+        t.Fatal(err)
+    }
+    cfg, err := written.Config()
+    if err != nil {
+        t.Fatal(err)
+    }
+    var inverted bool
+    for _, filter := range cfg.Accounts["123456789012"].Filters["EC2Instance"] {
+        if filter.Property == "tag:Env" {
+            inverted = filter.Invert == "true"
+        }
+    }
+    if !inverted {
+        t.Errorf("the inverted filter did not read back as inverted: %v", cfg.Accounts["123456789012"].Filters["EC2Instance"])
+    }
+}
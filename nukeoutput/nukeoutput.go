@@ -0,0 +1,257 @@
+package nukeoutput
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The state aws-nuke reports for a resource
+type State string
+
+const (
+    StateWouldRemove State = "would-remove"
+    StateFiltered    State = "filtered"
+    StateTriggered   State = "triggered"
+    StateWaiting     State = "waiting"
+    StateRemoved     State = "removed"
+    StateFailed      State = "failed"
+    // A message Shield does not recognise, so what aws-nuke does with the resource is not known
+    StateUnknown     State = "unknown"
+)
+
+// Every state, in the order aws-nuke moves a resource through them, then the unknown state
+var States = []State{StateFiltered, StateWouldRemove, StateTriggered, StateWaiting, StateRemoved, StateFailed, StateUnknown}
+
+// The kinds of line aws-nuke prints which Shield understands
+const (
+    // A line about a single resource
+    KindResource      = "resource"
+    // The totals printed when the scan is complete, "Scan complete: 10 total, 3 nukeable, 7 filtered."
+    KindScanComplete  = "scan-complete"
+    // The totals printed after each removal round, "Removal requested: 1 waiting, 0 failed, 7 skipped, 2 finished"
+    KindRemovalStatus = "removal-status"
+    // The totals printed when removal is over, "Nuke complete: 0 failed, 7 skipped, 3 finished."
+    KindNukeComplete  = "nuke-complete"
+)
+
+// A line of aws-nuke output. Resource lines have the form <region> - <type> - <ID> - [<properties>] - <state>, where the ID or the properties may be missing
+type Event struct {
+    Kind         string            `json:"kind"`
+    Region       string            `json:"region,omitempty"`
+    ResourceType string            `json:"type,omitempty"`
+    ID           string            `json:"id,omitempty"`
+    Properties   map[string]string `json:"properties,omitempty"`
+    State        State             `json:"state,omitempty"`
+    // The state as printed, e.g. "filtered by config", which carries the reason a resource was filtered
+    Message      string            `json:"message,omitempty"`
+    // The totals of summary lines, e.g. total, nukeable and filtered
+    Counts       map[string]int    `json:"counts,omitempty"`
+}
+
+// Whether aws-nuke would delete the resource
+func (e Event) WouldRemove() bool {
+    return e.State == StateWouldRemove
+}
+
+// Whether aws-nuke kept the resource, because of the config or the resource's own rules
+func (e Event) Filtered() bool {
+    return e.State == StateFiltered
+}
+
+// Whether Shield could not tell the state from the message aws-nuke printed
+func (e Event) Unknown() bool {
+    return e.State == StateUnknown
+}
+
+// Identifies a resource within its region and type: its ID, or its properties for resources which have no ID
+func (e Event) Identifier() string {
+    if e.ID != "" {
+        return e.ID
+    }
+    var names []string
+    for name := range e.Properties {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    var properties []string
+    for _, name := range names {
+        properties = append(properties, fmt.Sprintf("%s: %q", name, e.Properties[name]))
+    }
+    return "[" + strings.Join(properties, ", ") + "]"
+}
+
+// Identifies a resource across the events about it
+func (e Event) Key() string {
+    return e.Region + " - " + e.ResourceType + " - " + e.Identifier()
+}
+
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// A property of the form Key: "value"
+var property = regexp.MustCompile(`([^\s,\[\]]+): ("(?:[^"\\]|\\.)*")`)
+
+// A count in a summary line, e.g. "3 nukeable"
+var count = regexp.MustCompile(`(\d+) ([a-z]+)`)
+
+var summaryPrefixes = map[string]string{
+    "Scan complete:":     KindScanComplete,
+    "Removal requested:": KindRemovalStatus,
+    "Nuke complete:":     KindNukeComplete,
+}
+
+// Read r line by line, calling handle with each line and, when ok is set, the event parsed from it. Stops at the first error handle returns
+func Scan(r io.Reader, handle func(line string, event Event, ok bool) error) error {
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+    for scanner.Scan() {
+        event, ok := ParseLine(scanner.Text())
+        if err := handle(scanner.Text(), event, ok); err != nil {
+            return err
+        }
+    }
+    return scanner.Err()
+}
+
+// Parse all the events of r
+func Parse(r io.Reader) ([]Event, error) {
+    var events []Event
+    err := Scan(r, func(line string, event Event, ok bool) error {
+        if ok {
+            events = append(events, event)
+        }
+        return nil
+    })
+    return events, err
+}
+
+// Parse a single line of aws-nuke output. ok is false for lines which Shield does not understand
+func ParseLine(line string) (Event, bool) {
+    line = strings.TrimSpace(ansiEscape.ReplaceAllString(line, ""))
+
+    for prefix, kind := range summaryPrefixes {
+        if strings.HasPrefix(line, prefix) {
+            event := Event{Kind: kind, Counts: make(map[string]int)}
+            for _, match := range count.FindAllStringSubmatch(strings.TrimPrefix(line, prefix), -1) {
+                event.Counts[match[2]], _ = strconv.Atoi(match[1])
+            }
+            return event, true
+        }
+    }
+
+    parts := strings.SplitN(line, " - ", 3)
+    if len(parts) != 3 || strings.ContainsAny(parts[0], " \t") || strings.ContainsAny(parts[1], " \t") {
+        return Event{}, false
+    }
+    event := Event{Kind: KindResource, Region: parts[0], ResourceType: parts[1]}
+
+    // The state comes after the last separator, the ID and properties before it
+    rest := parts[2]
+    separator := strings.LastIndex(rest, " - ")
+    if separator == -1 {
+        return Event{}, false
+    }
+    event.Message = rest[separator+3:]
+    event.State = parseState(event.Message)
+    rest = rest[:separator]
+
+    if strings.HasSuffix(rest, "]") {
+        if strings.HasPrefix(rest, "[") {
+            event.Properties = parseProperties(rest)
+            rest = ""
+        } else if start := strings.Index(rest, " - ["); start != -1 {
+            event.Properties = parseProperties(rest[start+3:])
+            rest = rest[:start]
+        }
+    }
+    // Older aws-nuke versions quote the ID, e.g. 'i-01b489457a60298dd'
+    if len(rest) >= 2 && strings.HasPrefix(rest, "'") && strings.HasSuffix(rest, "'") {
+        rest = rest[1 : len(rest)-1]
+    }
+    event.ID = rest
+    return event, true
+}
+
+// The reasons aws-nuke prints for filtered resources: "filtered by config", and the phrasing of the reasons resources give for filtering
+// themselves out, e.g. "cannot delete default VPC", "cannot delete group 'default'", "already disabled" or "AWS managed key"
+var filterReasons = []*regexp.Regexp{
+    regexp.MustCompile(`^filtered\b`),
+    regexp.MustCompile(`(?i)^(cannot|can't|not deletable|already)\b`),
+    regexp.MustCompile(`(?i)\b(default|managed|protected|protection|service[- ]linked|reserved)\b`),
+}
+
+// aws-nuke prints a fixed message for every state but filtered, for which it prints the reason. aws-nuke v3 (libnuke) also gives
+// a reason for waiting, e.g. "waiting on dependencies (EC2Subnet)" or "waiting for parent removal". Any other message is unknown
+func parseState(message string) State {
+    switch message {
+    case "would remove":
+        return StateWouldRemove
+    case "triggered remove":
+        return StateTriggered
+    case "waiting":
+        return StateWaiting
+    case "removed":
+        return StateRemoved
+    case "failed":
+        return StateFailed
+    }
+    if strings.HasPrefix(message, "waiting ") {
+        return StateWaiting
+    }
+    for _, reason := range filterReasons {
+        if reason.MatchString(message) {
+            return StateFiltered
+        }
+    }
+    return StateUnknown
+}
+
+func parseProperties(block string) map[string]string {
+    properties := make(map[string]string)
+    for _, match := range property.FindAllStringSubmatch(block, -1) {
+        value, err := strconv.Unquote(match[2])
+        if err != nil {
+            value = strings.Trim(match[2], `"`)
+        }
+        properties[match[1]] = value
+    }
+    return properties
+}
+
+// The final state of every resource, and how many resources ended in each state
+type Summary struct {
+    Final  map[string]Event
+    States map[State]int
+}
+
+// Summarise the events: the state of each resource is the last one reported for it
+func Summarise(events []Event) Summary {
+    summary := Summary{Final: make(map[string]Event), States: make(map[State]int)}
+    for _, event := range events {
+        if event.Kind == KindResource {
+            summary.Final[event.Key()] = event
+        }
+    }
+    for _, event := range summary.Final {
+        summary.States[event.State]++
+    }
+    return summary
+}
+
+// The resources which ended in the given state, sorted
+func (s Summary) InState(state State) []Event {
+    var events []Event
+    for _, event := range s.Final {
+        if event.State == state {
+            events = append(events, event)
+        }
+    }
+    sort.Slice(events, func(i, j int) bool {
+        return events[i].Key() < events[j].Key()
+    })
+    return events
+}
@@ -0,0 +1,291 @@
+package nukeoutput
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLine(t *testing.T) {
+    tests := []struct {
+        name  string
+        line  string
+        ok    bool
+        event Event
+    }{
+        {
+            name:  "would remove, quoted ID",
+            line:  "eu-west-1 - EC2Instance - 'i-01b489457a60298dd' - would remove",
+            ok:    true,
+            event: Event{Kind: KindResource, Region: "eu-west-1", ResourceType: "EC2Instance", ID: "i-01b489457a60298dd", State: StateWouldRemove, Message: "would remove"},
+        },
+        {
+            name: "would remove, ID and properties",
+            line: `eu-west-1 - EC2VPC - vpc-0c3d9a4e1f2b7a6c5 - [ID: "vpc-0c3d9a4e1f2b7a6c5", IsDefault: "false", OwnerID: "123456789012"] - would remove`,
+            ok:   true,
+            event: Event{
+                Kind: KindResource, Region: "eu-west-1", ResourceType: "EC2VPC", ID: "vpc-0c3d9a4e1f2b7a6c5",
+                Properties: map[string]string{"ID": "vpc-0c3d9a4e1f2b7a6c5", "IsDefault": "false", "OwnerID": "123456789012"},
+                State:      StateWouldRemove, Message: "would remove",
+            },
+        },
+        {
+            name: "filtered by config, tag property",
+            line: `global - IAMRole - app-LambdaRole - [Name: "app-LambdaRole", Path: "/", tag:aws:cloudformation:stack-name: "app"] - filtered by config`,
+            ok:   true,
+            event: Event{
+                Kind: KindResource, Region: "global", ResourceType: "IAMRole", ID: "app-LambdaRole",
+                Properties: map[string]string{"Name": "app-LambdaRole", "Path": "/", "tag:aws:cloudformation:stack-name": "app"},
+                State:      StateFiltered, Message: "filtered by config",
+            },
+        },
+        {
+            name:  "filtered by the resource itself",
+            line:  "eu-west-1 - EC2SecurityGroup - 'sg-220e945a' - cannot delete group 'default'",
+            ok:    true,
+            event: Event{Kind: KindResource, Region: "eu-west-1", ResourceType: "EC2SecurityGroup", ID: "sg-220e945a", State: StateFiltered, Message: "cannot delete group 'default'"},
+        },
+        {
+            name:  "ID containing spaces and colons",
+            line:  "eu-west-1 - CloudWatchEventsTarget - Rule: app-Schedule Target ID: Target0 - filtered by config",
+            ok:    true,
+            event: Event{Kind: KindResource, Region: "eu-west-1", ResourceType: "CloudWatchEventsTarget", ID: "Rule: app-Schedule Target ID: Target0", State: StateFiltered, Message: "filtered by config"},
+        },
+        {
+            name: "properties without an ID",
+            line: `eu-west-1 - EC2Subnet - [DefaultForAz: "false", OwnerID: "123456789012"] - failed`,
+            ok:   true,
+            event: Event{
+                Kind: KindResource, Region: "eu-west-1", ResourceType: "EC2Subnet",
+                Properties: map[string]string{"DefaultForAz": "false", "OwnerID": "123456789012"},
+                State:      StateFailed, Message: "failed",
+            },
+        },
+        {
+            name:  "triggered remove",
+            line:  "eu-west-1 - EC2KeyPair - 'test' - triggered remove",
+            ok:    true,
+            event: Event{Kind: KindResource, Region: "eu-west-1", ResourceType: "EC2KeyPair", ID: "test", State: StateTriggered, Message: "triggered remove"},
+        },
+        {
+            name:  "waiting",
+            line:  "eu-west-1 - EC2Instance - 'i-01b489457a60298dd' - waiting",
+            ok:    true,
+            event: Event{Kind: KindResource, Region: "eu-west-1", ResourceType: "EC2Instance", ID: "i-01b489457a60298dd", State: StateWaiting, Message: "waiting"},
+        },
+        {
+            name:  "removed, with colours",
+            line:  "\x1b[36meu-west-1\x1b[0m - \x1b[33mS3Object\x1b[0m - s3://bucket/key - \x1b[32mremoved\x1b[0m",
+            ok:    true,
+            event: Event{Kind: KindResource, Region: "eu-west-1", ResourceType: "S3Object", ID: "s3://bucket/key", State: StateRemoved, Message: "removed"},
+        },
+        {
+            name:  "scan complete",
+            line:  "Scan complete: 13 total, 11 nukeable, 2 filtered.",
+            ok:    true,
+            event: Event{Kind: KindScanComplete, Counts: map[string]int{"total": 13, "nukeable": 11, "filtered": 2}},
+        },
+        {
+            name:  "removal requested",
+            line:  "Removal requested: 2 waiting, 6 failed, 5 skipped, 0 finished",
+            ok:    true,
+            event: Event{Kind: KindRemovalStatus, Counts: map[string]int{"waiting": 2, "failed": 6, "skipped": 5, "finished": 0}},
+        },
+        {
+            name:  "nuke complete",
+            line:  "Nuke complete: 0 failed, 7 skipped, 10 finished.",
+            ok:    true,
+            event: Event{Kind: KindNukeComplete, Counts: map[string]int{"failed": 0, "skipped": 7, "finished": 10}},
+        },
+        {name: "version banner", line: "aws-nuke version v1.0.39.gc2f318f - Fri Jul 28 16:26:41 CEST 2017 - c2f318f37b7d2dec0e646da3d4d05ab5296d5bce"},
+        {name: "confirmation prompt", line: "Do you really want to nuke the account with the ID 000000000000 and the alias 'aws-nuke-example'?"},
+        {name: "prompt answer", line: "> aws-nuke-example"},
+        {name: "dry run notice", line: "Would delete these resources. Provide --no-dry-run to actually destroy resources."},
+        {name: "blank", line: ""},
+        {name: "no state", line: "eu-west-1 - EC2Instance"},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            event, ok := ParseLine(test.line)
+            if ok != test.ok {
+                t.Fatalf("ParseLine(%q) ok = %v, want %v", test.line, ok, test.ok)
+            }
+            if ok && !reflect.DeepEqual(event, test.event) {
+                t.Errorf("ParseLine(%q) =\n%+v\nwant\n%+v", test.line, event, test.event)
+            }
+        })
+    }
+}
+
+func TestParseState(t *testing.T) {
+    tests := []struct {
+        message string
+        state   State
+    }{
+        {"would remove", StateWouldRemove},
+        {"triggered remove", StateTriggered},
+        {"waiting", StateWaiting},
+        {"removed", StateRemoved},
+        {"failed", StateFailed},
+        {"filtered by config", StateFiltered},
+        {"cannot delete default VPC", StateFiltered},
+        {"cannot delete group 'default'", StateFiltered},
+        {"already disabled", StateFiltered},
+        {"cannot delete AWS managed policy", StateFiltered},
+        {"service-linked role", StateFiltered},
+        // aws-nuke v3
+        {"waiting on dependencies (EC2Subnet)", StateWaiting},
+        {"waiting for parent removal", StateWaiting},
+        // Messages Shield does not recognise are not taken as filtered
+        {"would remove (dependency)", StateUnknown},
+        {"pending", StateUnknown},
+        {"", StateUnknown},
+        {"Filtered By Config", StateUnknown},
+    }
+    for _, test := range tests {
+        if got := parseState(test.message); got != test.state {
+            t.Errorf("parseState(%q) = %s, want %s", test.message, got, test.state)
+        }
+    }
+}
+
+func TestScan(t *testing.T) {
+    tests := []struct {
+        fixture string
+        kinds   map[string]int
+        states  map[State]int
+    }{
+        {
+            fixture: "readme-dry-run.txt",
+            kinds:   map[string]int{KindResource: 13, KindScanComplete: 1},
+            states:  map[State]int{StateWouldRemove: 11, StateFiltered: 2},
+        },
+        {
+            fixture: "readme-no-dry-run.txt",
+            kinds:   map[string]int{KindResource: 37, KindScanComplete: 1, KindRemovalStatus: 4, KindNukeComplete: 1},
+            states:  map[State]int{StateWouldRemove: 9, StateFiltered: 2, StateTriggered: 3, StateWaiting: 1, StateFailed: 13, StateRemoved: 9},
+        },
+        {
+            fixture: "properties-failed.txt",
+            kinds:   map[string]int{KindResource: 11, KindScanComplete: 1, KindRemovalStatus: 2},
+            states:  map[State]int{StateWouldRemove: 2, StateFiltered: 4, StateTriggered: 2, StateFailed: 2, StateRemoved: 1},
+        },
+        {
+            fixture: "v3-no-dry-run.txt",
+            kinds:   map[string]int{KindResource: 23, KindScanComplete: 1, KindRemovalStatus: 4, KindNukeComplete: 1},
+            states:  map[State]int{StateWouldRemove: 5, StateFiltered: 4, StateTriggered: 4, StateWaiting: 5, StateRemoved: 5},
+        },
+    }
+
+    for _, test := range tests {
+        t.Run(test.fixture, func(t *testing.T) {
+            file, err := os.Open(filepath.Join("testdata", test.fixture))
+            if err != nil {
+                t.Fatal(err)
+            }
+            defer file.Close()
+
+            kinds := make(map[string]int)
+            states := make(map[State]int)
+            lines := 0
+            err = Scan(file, func(line string, event Event, ok bool) error {
+                lines++
+                if ok {
+                    kinds[event.Kind]++
+                    if event.Kind == KindResource {
+                        states[event.State]++
+                    }
+                }
+                return nil
+            })
+            if err != nil {
+                t.Fatal(err)
+            }
+            if lines == 0 {
+                t.Fatal("no lines were scanned")
+            }
+            if !reflect.DeepEqual(kinds, test.kinds) {
+                t.Errorf("kinds = %v, want %v", kinds, test.kinds)
+            }
+            if !reflect.DeepEqual(states, test.states) {
+                t.Errorf("states = %v, want %v", states, test.states)
+            }
+        })
+    }
+}
+
+func TestScanStopsAtHandlerError(t *testing.T) {
+    input := "eu-west-1 - EC2KeyPair - 'a' - would remove\neu-west-1 - EC2KeyPair - 'b' - would remove\n"
+    calls := 0
+    err := Scan(strings.NewReader(input), func(line string, event Event, ok bool) error {
+        calls++
+        return os.ErrClosed
+    })
+    if err != os.ErrClosed || calls != 1 {
+        t.Errorf("Scan returned %v after %d calls, want %v after 1", err, calls, os.ErrClosed)
+    }
+}
+
+func TestSummarise(t *testing.T) {
+    tests := []struct {
+        fixture string
+        states  map[State]int
+        failed  []string
+    }{
+        {
+            fixture: "readme-dry-run.txt",
+            states:  map[State]int{StateWouldRemove: 11, StateFiltered: 2},
+        },
+        {
+            // Every resource is eventually removed, however often it failed on the way
+            fixture: "readme-no-dry-run.txt",
+            states:  map[State]int{StateFiltered: 2, StateRemoved: 9},
+        },
+        {
+            fixture: "properties-failed.txt",
+            states:  map[State]int{StateFiltered: 4, StateRemoved: 1, StateFailed: 1},
+            failed:  []string{"eu-west-1 - EC2VPC - vpc-0c3d9a4e1f2b7a6c5"},
+        },
+        {
+            fixture: "v3-no-dry-run.txt",
+            states:  map[State]int{StateFiltered: 4, StateRemoved: 5},
+        },
+    }
+
+    for _, test := range tests {
+        t.Run(test.fixture, func(t *testing.T) {
+            file, err := os.Open(filepath.Join("testdata", test.fixture))
+            if err != nil {
+                t.Fatal(err)
+            }
+            defer file.Close()
+            events, err := Parse(file)
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            summary := Summarise(events)
+            if !reflect.DeepEqual(summary.States, test.states) {
+                t.Errorf("states = %v, want %v", summary.States, test.states)
+            }
+            var failed []string
+            for _, event := range summary.InState(StateFailed) {
+                failed = append(failed, event.Key())
+            }
+            if !reflect.DeepEqual(failed, test.failed) {
+                t.Errorf("failed = %v, want %v", failed, test.failed)
+            }
+        })
+    }
+}
+
+func TestIdentifierWithoutID(t *testing.T) {
+    event := Event{Region: "eu-west-1", ResourceType: "EC2Subnet", Properties: map[string]string{"OwnerID": "1", "DefaultForAz": "false"}}
+    want := `[DefaultForAz: "false", OwnerID: "1"]`
+    if got := event.Identifier(); got != want {
+        t.Errorf("Identifier() = %q, want %q", got, want)
+    }
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"awsnukeshield/helpers"
+	"awsnukeshield/resources"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Exit codes, so that pipelines can tell how far a run got. 2 is left out, as the flag package exits with it on invalid flags
+const (
+    // The config was generated and the dry run passed the interlock, but nothing was removed: a dry run, or plan
+    exitConfigGenerated   = 0
+    // Any other failure, e.g. invalid arguments or incomplete discovery
+    exitError             = 1
+    // Resource types of resources to preserve could not all be mapped to aws-nuke types
+    exitMappingIncomplete = 3
+    // aws-nuke itself failed
+    exitNukeFailed        = 4
+    // The aws-nuke dry run would remove resources which Shield preserves
+    exitInterlockFailed   = 5
+    // apply found that the run no longer matches the plan
+    exitPlanMismatch      = 6
+    // aws-nuke was run with -no-dry-run, and removed everything it was to remove
+    exitNukeComplete      = 7
+)
+
+var errMappingIncomplete = errors.New("resource type mapping incomplete")
+var errNukeFailed = errors.New("aws-nuke failed")
+var errInterlockFailed = errors.New("safety interlock failed")
+var errPlanMismatch = errors.New("the run does not match the plan")
+
+// Commands which take the same flags as a plain run. plan saves what the dry run would remove, apply only removes it if nothing changed since
+const (
+    commandPlan  = "plan"
+    commandApply = "apply"
+)
+
+// Flags which do not change what is preserved, so are left out of the hash of the preservation inputs. -non-interactive is among them
+// so that a plan made interactively can be applied by a pipeline: any mapping chosen when prompted is saved to the mapping file, which is hashed
+var nonPreservationFlags = []string{"plan-file", "nuke-output", "no-dry-run", "non-interactive", "report", "report-markdown"}
+
+// Policies for CFN types with several candidate aws-nuke types when running non-interactively
+const (
+    // Stop without running aws-nuke
+    ambiguousPolicyFail        = "fail"
+    // Leave resources of the type out of the config file, and report them as unmapped
+    ambiguousPolicySkip        = "skip"
+    // Like fail, but the mapping file must exist, as it is expected to hold every decision
+    ambiguousPolicyMappingFile = "mapping-file"
+)
+
+// How aws-nuke's output is shown, given with -nuke-output
+const (
+    // aws-nuke's own output, as it is printed
+    nukeOutputText = "text"
+    // Each line of aws-nuke output which Shield understands, as a JSON object on a line of its own
+    nukeOutputJSON = "json"
+)
+
+func validateNukeOutput(format string) error {
+    switch format {
+    case nukeOutputText, nukeOutputJSON:
+        return nil
+    }
+    return fmt.Errorf("invalid -nuke-output %q, expected %s or %s", format, nukeOutputText, nukeOutputJSON)
+}
+
+// How CFN types are mapped to aws-nuke types
+type mappingOptions struct {
+    nonInteractive  bool
+    ambiguousPolicy string
+    failOnUnmapped  bool
+}
+
+func (o mappingOptions) validate() error {
+    switch o.ambiguousPolicy {
+    case ambiguousPolicyFail, ambiguousPolicySkip, ambiguousPolicyMappingFile:
+        return nil
+    }
+    return fmt.Errorf("invalid -ambiguous-mappings %q, expected %s, %s or %s", o.ambiguousPolicy, ambiguousPolicyFail, ambiguousPolicySkip, ambiguousPolicyMappingFile)
+}
+
+// Flags selecting the stacks whose resources are preserved
+type stackOptions struct {
+    regexes              helpers.StringListFlag
+    excludeRegexes       helpers.StringListFlag
+    tags                 resources.TagSelectorListFlag
+    terminationProtected bool
+    descriptionRegexes   helpers.StringListFlag
+    roleRegexes          helpers.StringListFlag
+    stackSets            helpers.StringListFlag
+    stackSetNameFallback bool
+    createdAfter         string
+    createdBefore        string
+    updatedAfter         string
+    updatedBefore        string
+}
+
+// Turn the flags into stack selection criteria, with ages counted back from now
+func (o stackOptions) criteria(now time.Time) (resources.StackCriteria, error) {
+    tagFilters, err := resources.ParseTagSelectors(o.tags)
+    if err != nil {
+        return resources.StackCriteria{}, fmt.Errorf("-stack-tags: %w", err)
+    }
+    criteria := resources.StackCriteria{
+        Includes:             o.regexes,
+        Excludes:             o.excludeRegexes,
+        Tags:                 tagFilters,
+        TerminationProtected: o.terminationProtected,
+        DescriptionRegexes:   o.descriptionRegexes,
+        RoleRegexes:          o.roleRegexes,
+        StackSets:            o.stackSets,
+        StackSetNameFallback: o.stackSetNameFallback,
+    }
+
+    times := []struct {
+        flag  string
+        value string
+        into  *time.Time
+    }{
+        {"-created-after", o.createdAfter, &criteria.CreatedAfter},
+        {"-created-before", o.createdBefore, &criteria.CreatedBefore},
+        {"-updated-after", o.updatedAfter, &criteria.UpdatedAfter},
+        {"-updated-before", o.updatedBefore, &criteria.UpdatedBefore},
+    }
+    for _, t := range times {
+        parsed, err := resources.ParseTime(t.value, now)
+        if err != nil {
+            return resources.StackCriteria{}, fmt.Errorf("%s: %w", t.flag, err)
+        }
+        *t.into = parsed
+    }
+    return criteria, nil
+}
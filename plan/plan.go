@@ -0,0 +1,189 @@
+package plan
+
+import (
+	"awsnukeshield/nukeoutput"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// The default location of the plan file
+const DefaultPlanFile = "shield-plan.json"
+
+// The environment variable holding the key plan files are signed with
+const KeyVariable = "SHIELD_PLAN_KEY"
+
+// The version of the plan file format, bumped whenever its contents change
+const formatVersion = 2
+
+// A resource which the aws-nuke dry run would remove
+type Deletion struct {
+    Region       string `json:"region"`
+    ResourceType string `json:"type"`
+    // The resource ID, or its properties for resources which have no ID
+    Resource     string `json:"resource"`
+}
+
+func (d Deletion) String() string {
+    return fmt.Sprintf("%s - %s - %s", d.Region, d.ResourceType, d.Resource)
+}
+
+// What a reviewer approves: the account, what the preserved resources were derived from, and what the dry run would remove
+type Plan struct {
+    FormatVersion  int        `json:"formatVersion"`
+    CreatedAt      time.Time  `json:"createdAt"`
+    Account        string     `json:"account"`
+    AwsNukeVersion string     `json:"awsNukeVersion"`
+    BaseConfig     string     `json:"baseConfig"`
+    // SHA-256 of the base config file
+    BaseConfigHash string     `json:"baseConfigHash"`
+    // SHA-256 of the preservation inputs: the flags, rules files and mapping file
+    InputsHash     string     `json:"inputsHash"`
+    Deletions      []Deletion `json:"deletions"`
+    // HMAC-SHA256 of the plan without its signature, keyed with the plan key
+    Signature      string     `json:"signature"`
+}
+
+// Create a plan of the deletions of the dry run events
+func New(account string, awsNukeVersion string, baseConfig string, baseConfigHash string, inputsHash string, events []nukeoutput.Event) Plan {
+    return Plan{
+        FormatVersion:  formatVersion,
+        CreatedAt:      time.Now().UTC(),
+        Account:        account,
+        AwsNukeVersion: awsNukeVersion,
+        BaseConfig:     baseConfig,
+        BaseConfigHash: baseConfigHash,
+        InputsHash:     inputsHash,
+        Deletions:      Deletions(events),
+    }
+}
+
+// Return the key plan files are signed with, from the environment
+func Key() ([]byte, error) {
+    key := os.Getenv(KeyVariable)
+    if key == "" {
+        return nil, fmt.Errorf("%s must be set to the key plan files are signed with", KeyVariable)
+    }
+    return []byte(key), nil
+}
+
+// Sign the plan and write it to path
+func (p Plan) Save(path string, key []byte) error {
+    signature, err := p.mac(key)
+    if err != nil {
+        return err
+    }
+    p.Signature = hex.EncodeToString(signature)
+    data, err := json.MarshalIndent(p, "", "  ")
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Read the plan at path, checking that it was signed with key and has not been changed since
+func Load(path string, key []byte) (Plan, error) {
+    var p Plan
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return p, err
+    }
+    if err := json.Unmarshal(data, &p); err != nil {
+        return p, fmt.Errorf("unable to parse the plan %s: %w", path, err)
+    }
+    if p.FormatVersion != formatVersion {
+        return p, fmt.Errorf("the plan %s has format version %d, expected %d. Run plan again", path, p.FormatVersion, formatVersion)
+    }
+    expected, err := p.mac(key)
+    if err != nil {
+        return p, err
+    }
+    actual, err := hex.DecodeString(p.Signature)
+    if err != nil || !hmac.Equal(actual, expected) {
+        return p, fmt.Errorf("the signature of the plan %s is invalid: it was changed after it was written, or signed with another key", path)
+    }
+    return p, nil
+}
+
+// The HMAC of the plan without its signature
+func (p Plan) mac(key []byte) ([]byte, error) {
+    p.Signature = ""
+    data, err := json.Marshal(p)
+    if err != nil {
+        return nil, err
+    }
+    mac := hmac.New(sha256.New, key)
+    mac.Write(data)
+    return mac.Sum(nil), nil
+}
+
+// The resources the dry run events would remove, sorted and without duplicates
+func Deletions(events []nukeoutput.Event) []Deletion {
+    seen := make(map[Deletion]bool)
+    var deletions []Deletion
+    for _, event := range events {
+        if !event.WouldRemove() {
+            continue
+        }
+        deletion := Deletion{Region: event.Region, ResourceType: event.ResourceType, Resource: event.Identifier()}
+        if !seen[deletion] {
+            seen[deletion] = true
+            deletions = append(deletions, deletion)
+        }
+    }
+    sort.Slice(deletions, func(i, j int) bool {
+        return deletions[i].String() < deletions[j].String()
+    })
+    return deletions
+}
+
+// Compare the plan's deletions with the current ones, returning those which are new and those which are no longer removed
+func (p Plan) CompareDeletions(current []Deletion) (added []Deletion, dropped []Deletion) {
+    planned := make(map[Deletion]bool)
+    for _, deletion := range p.Deletions {
+        planned[deletion] = true
+    }
+    now := make(map[Deletion]bool)
+    for _, deletion := range current {
+        now[deletion] = true
+        if !planned[deletion] {
+            added = append(added, deletion)
+        }
+    }
+    for _, deletion := range p.Deletions {
+        if !now[deletion] {
+            dropped = append(dropped, deletion)
+        }
+    }
+    return added, dropped
+}
+
+// Hash a set of parts, whatever their order. Each part is hashed with its length, so that moving bytes between parts changes the hash.
+// Callers whose parts are ordered must label them, e.g. with their position
+func Hash(parts ...[]byte) string {
+    framed := make([]string, 0, len(parts))
+    for _, part := range parts {
+        framed = append(framed, fmt.Sprintf("%d:%s", len(part), part))
+    }
+    sort.Strings(framed)
+
+    hash := sha256.New()
+    for _, part := range framed {
+        hash.Write([]byte(part))
+    }
+    return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Hash the contents of the file at path
+func HashFile(path string) (string, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return "", err
+    }
+    return Hash(data), nil
+}
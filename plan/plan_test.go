@@ -0,0 +1,174 @@
+package plan
+
+import (
+	"awsnukeshield/nukeoutput"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testPlan() Plan {
+    events := []nukeoutput.Event{
+        {Kind: nukeoutput.KindResource, Region: "eu-west-1", ResourceType: "S3Bucket", ID: "s3://scratch", State: nukeoutput.StateWouldRemove},
+        {Kind: nukeoutput.KindResource, Region: "eu-west-1", ResourceType: "EC2Instance", ID: "i-0a1b2c3d", State: nukeoutput.StateWouldRemove},
+        {Kind: nukeoutput.KindResource, Region: "eu-west-1", ResourceType: "S3Bucket", ID: "s3://app-logs", State: nukeoutput.StateFiltered},
+        {Kind: nukeoutput.KindScanComplete, Counts: map[string]int{"total": 3, "nukeable": 2, "filtered": 1}},
+    }
+    return New("123456789012", "v2.25.0", "nuke-config.yml", Hash([]byte("base")), Hash([]byte("inputs")), events)
+}
+
+func TestSaveLoad(t *testing.T) {
+    path := filepath.Join(t.TempDir(), DefaultPlanFile)
+    saved := testPlan()
+    if err := saved.Save(path, []byte("key")); err != nil {
+        t.Fatal(err)
+    }
+
+    loaded, err := Load(path, []byte("key"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if loaded.Signature == "" {
+        t.Error("the loaded plan has no signature")
+    }
+    loaded.Signature = ""
+    if !loaded.CreatedAt.Equal(saved.CreatedAt) {
+        t.Errorf("CreatedAt = %v, want %v", loaded.CreatedAt, saved.CreatedAt)
+    }
+    loaded.CreatedAt = saved.CreatedAt
+    if !reflect.DeepEqual(loaded, saved) {
+        t.Errorf("loaded\n%+v\nwant\n%+v", loaded, saved)
+    }
+    want := []Deletion{{"eu-west-1", "EC2Instance", "i-0a1b2c3d"}, {"eu-west-1", "S3Bucket", "s3://scratch"}}
+    if !reflect.DeepEqual(loaded.Deletions, want) {
+        t.Errorf("deletions = %v, want %v", loaded.Deletions, want)
+    }
+}
+
+func TestLoadRejectsTamperedPlan(t *testing.T) {
+    tests := []struct {
+        name   string
+        tamper func(p map[string]interface{})
+    }{
+        {"deletion removed", func(p map[string]interface{}) { p["deletions"] = p["deletions"].([]interface{})[:1] }},
+        {"account changed", func(p map[string]interface{}) { p["account"] = "210987654321" }},
+        {"inputs hash changed", func(p map[string]interface{}) { p["inputsHash"] = Hash([]byte("other inputs")) }},
+        {"signature removed", func(p map[string]interface{}) { p["signature"] = "" }},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            path := filepath.Join(t.TempDir(), DefaultPlanFile)
+            if err := testPlan().Save(path, []byte("key")); err != nil {
+                t.Fatal(err)
+            }
+            data, err := os.ReadFile(path)
+            if err != nil {
+                t.Fatal(err)
+            }
+            var body map[string]interface{}
+            if err := json.Unmarshal(data, &body); err != nil {
+                t.Fatal(err)
+            }
+            test.tamper(body)
+            data, err = json.Marshal(body)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if err := os.WriteFile(path, data, 0644); err != nil {
+                t.Fatal(err)
+            }
+
+            if _, err := Load(path, []byte("key")); err == nil || !strings.Contains(err.Error(), "signature") {
+                t.Errorf("Load() error = %v, want an invalid signature", err)
+            }
+        })
+    }
+}
+
+func TestLoadRejectsOtherFormatVersion(t *testing.T) {
+    path := filepath.Join(t.TempDir(), DefaultPlanFile)
+    p := testPlan()
+    p.FormatVersion = formatVersion - 1
+    if err := p.Save(path, []byte("key")); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := Load(path, []byte("key")); err == nil || !strings.Contains(err.Error(), "format version") {
+        t.Errorf("Load() error = %v, want a format version error", err)
+    }
+}
+
+func TestKey(t *testing.T) {
+    path := filepath.Join(t.TempDir(), DefaultPlanFile)
+    t.Setenv(KeyVariable, "approver key")
+    key, err := Key()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := testPlan().Save(path, key); err != nil {
+        t.Fatal(err)
+    }
+
+    t.Setenv(KeyVariable, "another key")
+    key, err = Key()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := Load(path, key); err == nil {
+        t.Error("Load() accepted a plan signed with another key")
+    }
+
+    t.Setenv(KeyVariable, "")
+    if _, err := Key(); err == nil || !strings.Contains(err.Error(), KeyVariable) {
+        t.Errorf("Key() error = %v, want %s to be required", err, KeyVariable)
+    }
+    if _, err := Load(path, nil); err == nil {
+        t.Error("Load() accepted a plan with an empty key")
+    }
+}
+
+func TestCompareDeletions(t *testing.T) {
+    p := Plan{Deletions: []Deletion{
+        {"eu-west-1", "EC2Instance", "i-0a1b2c3d"},
+        {"eu-west-1", "S3Bucket", "s3://scratch"},
+        {"global", "IAMRole", "old-role"},
+    }}
+    current := []Deletion{
+        {"eu-west-1", "EC2Instance", "i-0a1b2c3d"},
+        {"eu-west-1", "S3Bucket", "s3://app-logs"},
+        {"eu-west-1", "S3Bucket", "s3://scratch"},
+    }
+
+    added, dropped := p.CompareDeletions(current)
+    if want := []Deletion{{"eu-west-1", "S3Bucket", "s3://app-logs"}}; !reflect.DeepEqual(added, want) {
+        t.Errorf("added = %v, want %v", added, want)
+    }
+    if want := []Deletion{{"global", "IAMRole", "old-role"}}; !reflect.DeepEqual(dropped, want) {
+        t.Errorf("dropped = %v, want %v", dropped, want)
+    }
+
+    added, dropped = p.CompareDeletions(p.Deletions)
+    if len(added) != 0 || len(dropped) != 0 {
+        t.Errorf("comparing the plan with itself gave %v added, %v dropped", added, dropped)
+    }
+}
+
+func TestHash(t *testing.T) {
+    a, b, c := []byte("-regions=eu-west-1"), []byte("rules[0] filters: {}"), []byte("mapping ")
+    want := Hash(a, b, c)
+    for _, parts := range [][][]byte{{a, c, b}, {b, a, c}, {b, c, a}, {c, a, b}, {c, b, a}} {
+        if got := Hash(parts...); got != want {
+            t.Errorf("Hash(%q) = %s, want %s", parts, got, want)
+        }
+    }
+
+    if Hash([]byte("ab"), []byte("c")) == Hash([]byte("a"), []byte("bc")) {
+        t.Error("moving bytes between parts does not change the hash")
+    }
+    if Hash(a, b) == Hash(a, b, c) {
+        t.Error("adding a part does not change the hash")
+    }
+}
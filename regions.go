@@ -0,0 +1,105 @@
+package main
+
+import (
+	"awsnukeshield/helpers"
+	"awsnukeshield/resources"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Region used for account level API calls made before the regions to scan are known
+const defaultAPIRegion = "us-east-1"
+
+// Return the regions to search for stacks: the -regions override if provided, else the regions enabled in the account if discoverRegions is set,
+// else the regions listed in the base config. The global pseudo region and duplicates are dropped
+func selectRegions(logger *zap.Logger, configRegions []string, regionOverride []string, discoverRegions bool) ([]string, string, error) {
+    var regions []string
+    var source string
+
+    if len(regionOverride) != 0 {
+        regions = regionOverride
+        source = "-regions"
+    } else if discoverRegions {
+        enabledRegions, err := resources.GetEnabledRegions(logger, apiRegion(configRegions))
+        if err != nil {
+            return nil, "", err
+        }
+        regions = enabledRegions
+        source = "regions enabled in the account"
+    } else {
+        regions = configRegions
+        source = "regions of the base config"
+    }
+
+    return cleanRegions(regions), source, nil
+}
+
+// Trim the regions, dropping empty entries, duplicates and the global pseudo region
+func cleanRegions(regions []string) []string {
+    var cleaned []string
+    for _, region := range regions {
+        region = strings.TrimSpace(region)
+        if region != "" && region != resources.GlobalRegion {
+            cleaned = append(cleaned, region)
+        }
+    }
+    return helpers.RemoveDuplicates[string](cleaned)
+}
+
+// Return a region to use for API calls which are not tied to a region
+func apiRegion(regions []string) string {
+    cleaned := cleanRegions(regions)
+    if len(cleaned) != 0 {
+        return cleaned[0]
+    }
+    return defaultAPIRegion
+}
+
+// Print a warning for every difference between the regions aws-nuke will run in and the regions searched for stacks.
+// Resources in a region which aws-nuke nukes but Shield did not search are NOT protected. Returns true if any warning was printed
+func warnOnRegionMismatch(configRegions []string, scannedRegions []string) bool {
+    warnings := regionMismatches(configRegions, scannedRegions)
+    if len(warnings) != 0 {
+        fmt.Println("\n\n!!!!!!!!!! WARNING: REGION MISMATCH !!!!!!!!!!")
+        fmt.Println()
+        for _, warning := range warnings {
+            fmt.Printf("- %s\n", warning)
+        }
+        fmt.Println("\nAlign the regions of the base config with the regions searched, or use -regions / -discover-regions")
+    }
+
+    return len(warnings) != 0
+}
+
+// Describe every difference between the regions of the base config and the regions searched
+func regionMismatches(configRegions []string, scannedRegions []string) []string {
+    var warnings []string
+
+    // Both lists are trimmed and deduplicated once, so that a region differing only in whitespace gives a single warning
+    seen := make(map[string]int)
+    for _, region := range configRegions {
+        seen[strings.TrimSpace(region)]++
+    }
+    configClean := cleanRegions(configRegions)
+    scannedClean := cleanRegions(scannedRegions)
+
+    for _, region := range configClean {
+        if count := seen[region]; count > 1 {
+            warnings = append(warnings, fmt.Sprintf("%s is listed %d times in the regions of the base config", region, count))
+        }
+    }
+    for _, region := range configClean {
+        if helpers.FindItemExact(scannedClean, region) == -1 {
+            warnings = append(warnings, fmt.Sprintf("%s is in the regions of the base config, so aws-nuke will nuke it, but it was NOT searched for stacks. Nothing in it is protected by stack regexes", region))
+        }
+    }
+    for _, region := range scannedClean {
+        if helpers.FindItemExact(configClean, region) == -1 {
+            warnings = append(warnings, fmt.Sprintf("%s was searched for stacks, but is not in the regions of the base config, so aws-nuke will not run in it", region))
+        }
+    }
+
+    return warnings
+}
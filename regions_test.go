@@ -0,0 +1,44 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRegionMismatches(t *testing.T) {
+    tests := []struct {
+        name     string
+        config   []string
+        scanned  []string
+        warnings []string
+    }{
+        {name: "same regions", config: []string{"eu-west-1", "global"}, scanned: []string{"eu-west-1"}},
+        {name: "whitespace only", config: []string{" eu-west-1 ", "us-east-1"}, scanned: []string{"us-east-1", "eu-west-1"}},
+        {
+            name:     "one region not searched",
+            config:   []string{"eu-west-1 ", "us-east-1"},
+            scanned:  []string{"us-east-1"},
+            warnings: []string{"eu-west-1 is in the regions of the base config, so aws-nuke will nuke it, but it was NOT searched for stacks. Nothing in it is protected by stack regexes"},
+        },
+        {
+            name:     "one region not in the config",
+            config:   []string{"eu-west-1"},
+            scanned:  []string{"eu-west-1", " us-east-1"},
+            warnings: []string{"us-east-1 was searched for stacks, but is not in the regions of the base config, so aws-nuke will not run in it"},
+        },
+        {
+            name:     "duplicate differing in whitespace",
+            config:   []string{"eu-west-1", "eu-west-1 "},
+            scanned:  []string{"eu-west-1"},
+            warnings: []string{"eu-west-1 is listed 2 times in the regions of the base config"},
+        },
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            if warnings := regionMismatches(test.config, test.scanned); !reflect.DeepEqual(warnings, test.warnings) {
+                t.Errorf("regionMismatches(%q, %q) =\n%q\nwant\n%q", test.config, test.scanned, warnings, test.warnings)
+            }
+        })
+    }
+}
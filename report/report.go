@@ -0,0 +1,361 @@
+package report
+
+import (
+	"awsnukeshield/interlock"
+	"awsnukeshield/nukeconfig"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Why a resource is preserved
+const (
+    // A child of a selected stack, or the stack itself
+    ReasonStack     = "stack"
+    // A child of a stack whose exports are imported by a preserved stack, or the stack itself
+    ReasonImport    = "import"
+    // Retained (DeletionPolicy: Retain) from a deleted stack, with -preserve-retained
+    ReasonRetained  = "retained"
+    // Carries all the tags, with -all-tags
+    ReasonAllTags   = "all-tags"
+    // The identity Shield runs as, or part of it
+    ReasonCaller    = "caller"
+    // Carries one of the tags, filtered by the tag filters
+    ReasonTag       = "tag"
+    // Filtered by a static filter of the rules
+    ReasonRule      = "rule"
+    // A companion of a preserved resource, as listed in the rules
+    ReasonCompanion = "companion"
+)
+
+// A stack whose resources are preserved
+type Stack struct {
+    Region string `json:"region"`
+    Name   string `json:"name"`
+    ID     string `json:"id"`
+    Status string `json:"status"`
+    Reason string `json:"reason"`
+    // The selector decision, or the export which pulled the stack in
+    Detail string `json:"detail"`
+}
+
+// A resource to preserve, with the filter Shield emitted for it. AwsNukeType and Filter are empty for unmapped resources
+type Resource struct {
+    Region      string             `json:"region"`
+    CFNType     string             `json:"cfnType"`
+    PhysicalID  string             `json:"physicalId"`
+    LogicalID   string             `json:"logicalId,omitempty"`
+    // The stacks from the selected stack down to the stack which owns the resource
+    Chain       []string           `json:"chain,omitempty"`
+    Reason      string             `json:"reason"`
+    Detail      string             `json:"detail,omitempty"`
+    AwsNukeType string             `json:"awsNukeType,omitempty"`
+    Filter      *nukeconfig.Filter `json:"filter,omitempty"`
+}
+
+// A filter emitted for a whole aws-nuke type, rather than for a discovered resource
+type TypeFilter struct {
+    AwsNukeType string            `json:"awsNukeType"`
+    Filter      nukeconfig.Filter `json:"filter"`
+    Reason      string            `json:"reason"`
+    Detail      string            `json:"detail,omitempty"`
+}
+
+// A preserved resource which the dry run did not confirm filtered
+type InterlockResult struct {
+    AwsNukeType string            `json:"awsNukeType"`
+    Identity    string            `json:"identity"`
+    Source      string            `json:"source,omitempty"`
+    Filter      nukeconfig.Filter `json:"filter"`
+    Outcome     string            `json:"outcome"`
+}
+
+// A resource of the dry run: one carrying a preserved tag which would be removed, or one whose state is unknown
+type DryRunResource struct {
+    Region      string `json:"region"`
+    AwsNukeType string `json:"awsNukeType"`
+    ID          string `json:"id"`
+    // What aws-nuke printed about the resource, for those whose state is unknown
+    Message     string `json:"message,omitempty"`
+}
+
+// The safety interlock's check of the dry run
+type Interlock struct {
+    // Whether the check let the run go ahead
+    Passed        bool              `json:"passed"`
+    Filtered      int               `json:"filtered"`
+    WouldRemove   int               `json:"wouldRemove"`
+    Unknown       int               `json:"unknown"`
+    NotSeen       int               `json:"notSeen"`
+    NotScanned    int               `json:"notScanned"`
+    NoneFound     int               `json:"noneFound"`
+    // Every preserved resource not confirmed filtered
+    Results       []InterlockResult `json:"results"`
+    TagMisses     []DryRunResource  `json:"tagMisses"`
+    // Every resource, preserved or not, for which aws-nuke printed a message Shield does not recognise
+    UnknownStates []DryRunResource  `json:"unknownStates"`
+}
+
+type DiscoveryError struct {
+    Region string `json:"region"`
+    Stack  string `json:"stack,omitempty"`
+    Error  string `json:"error"`
+}
+
+// Everything Shield decided to preserve, and why
+type Report struct {
+    Account         string           `json:"account"`
+    BaseConfig      string           `json:"baseConfig"`
+    GeneratedConfig string           `json:"generatedConfig"`
+    Stacks          []Stack          `json:"stacks"`
+    Resources       []Resource       `json:"resources"`
+    Unmapped        []Resource       `json:"unmapped"`
+    // The filters which were not emitted for a discovered resource: companion, tag and rule filters
+    Filters         []TypeFilter     `json:"filters"`
+    ResourceTypes   []string         `json:"excludedResourceTypes"`
+    DiscoveryErrors []DiscoveryError `json:"discoveryErrors"`
+    // Missing when the run stopped before the dry run
+    Interlock       *Interlock       `json:"interlock,omitempty"`
+    // The error the run stopped on before aws-nuke removed anything, in which case the report holds what was done up to then
+    Error           string           `json:"error,omitempty"`
+}
+
+// Create an empty report, whose lists are written as empty rather than null
+func New(account string, baseConfig string, generatedConfig string) *Report {
+    return &Report{
+        Account:         account,
+        BaseConfig:      baseConfig,
+        GeneratedConfig: generatedConfig,
+        Stacks:          []Stack{},
+        Resources:       []Resource{},
+        Unmapped:        []Resource{},
+        Filters:         []TypeFilter{},
+        ResourceTypes:   []string{},
+        DiscoveryErrors: []DiscoveryError{},
+    }
+}
+
+// Add a discovered resource to preserve
+func (r *Report) AddResource(resource Resource) {
+    r.Resources = append(r.Resources, resource)
+}
+
+// Record the filters emitted for the resources, from the expectations of the interlock. Resources without a filter were not mapped to an aws-nuke type,
+// and are moved to Unmapped. Companion filters are added to Filters
+func (r *Report) AddExpectations(expectations []interlock.Expectation) {
+    type key struct {
+        cfnType    string
+        physicalID string
+    }
+    emitted := make(map[key]interlock.Expectation)
+    for _, expectation := range expectations {
+        if expectation.CFNType == "" {
+            r.Filters = append(r.Filters, TypeFilter{AwsNukeType: expectation.AwsNukeType, Filter: expectation.Filter, Reason: ReasonCompanion, Detail: expectation.Source})
+            continue
+        }
+        emitted[key{expectation.CFNType, expectation.Identity}] = expectation
+    }
+
+    mapped := []Resource{}
+    for _, resource := range r.Resources {
+        expectation, ok := emitted[key{resource.CFNType, resource.PhysicalID}]
+        if !ok {
+            r.Unmapped = append(r.Unmapped, resource)
+            continue
+        }
+        filter := expectation.Filter
+        resource.AwsNukeType = expectation.AwsNukeType
+        resource.Filter = &filter
+        mapped = append(mapped, resource)
+    }
+    r.Resources = mapped
+}
+
+// Record the outcome of the safety interlock, and whether it let the run go ahead
+func (r *Report) AddInterlock(interlockReport interlock.Report, passed bool) {
+    r.Interlock = &Interlock{
+        Passed:        passed,
+        Filtered:      interlockReport.Filtered,
+        WouldRemove:   interlockReport.WouldRemove,
+        Unknown:       interlockReport.Unknown,
+        NotSeen:       interlockReport.NotSeen,
+        NotScanned:    interlockReport.NotScanned,
+        NoneFound:     interlockReport.NoneFound,
+        Results:       []InterlockResult{},
+        TagMisses:     []DryRunResource{},
+        UnknownStates: []DryRunResource{},
+    }
+    for _, result := range interlockReport.Results {
+        if result.Outcome == interlock.OutcomeFiltered {
+            continue
+        }
+        r.Interlock.Results = append(r.Interlock.Results, InterlockResult{
+            AwsNukeType: result.Expectation.AwsNukeType,
+            Identity:    result.Expectation.Identity,
+            Source:      result.Expectation.Source,
+            Filter:      result.Expectation.Filter,
+            Outcome:     result.Outcome,
+        })
+    }
+    for _, event := range interlockReport.TagMisses {
+        r.Interlock.TagMisses = append(r.Interlock.TagMisses, DryRunResource{Region: event.Region, AwsNukeType: event.ResourceType, ID: event.ID})
+    }
+    for _, event := range interlockReport.UnknownStates {
+        r.Interlock.UnknownStates = append(r.Interlock.UnknownStates, DryRunResource{Region: event.Region, AwsNukeType: event.ResourceType, ID: event.ID, Message: event.Message})
+    }
+}
+
+// Sort every list, so that the same inputs always give the same report
+func (r *Report) Sort() {
+    sort.SliceStable(r.Stacks, func(i, j int) bool {
+        a, b := r.Stacks[i], r.Stacks[j]
+        return compare(a.Region, b.Region, a.Name, b.Name, a.ID, b.ID) < 0
+    })
+    sortResources(r.Resources)
+    sortResources(r.Unmapped)
+    sort.SliceStable(r.Filters, func(i, j int) bool {
+        a, b := r.Filters[i], r.Filters[j]
+        return compare(a.AwsNukeType, b.AwsNukeType, a.Reason, b.Reason, a.Filter.Property, b.Filter.Property, a.Filter.Value, b.Filter.Value, a.Detail, b.Detail) < 0
+    })
+    sort.Strings(r.ResourceTypes)
+    sort.SliceStable(r.DiscoveryErrors, func(i, j int) bool {
+        a, b := r.DiscoveryErrors[i], r.DiscoveryErrors[j]
+        return compare(a.Region, b.Region, a.Stack, b.Stack, a.Error, b.Error) < 0
+    })
+    if r.Interlock != nil {
+        sort.SliceStable(r.Interlock.Results, func(i, j int) bool {
+            a, b := r.Interlock.Results[i], r.Interlock.Results[j]
+            return compare(a.Outcome, b.Outcome, a.AwsNukeType, b.AwsNukeType, a.Identity, b.Identity, a.Source, b.Source) < 0
+        })
+        sortDryRunResources(r.Interlock.TagMisses)
+        sortDryRunResources(r.Interlock.UnknownStates)
+    }
+}
+
+func sortResources(resources []Resource) {
+    sort.SliceStable(resources, func(i, j int) bool {
+        a, b := resources[i], resources[j]
+        return compare(a.Region, b.Region, a.CFNType, b.CFNType, a.PhysicalID, b.PhysicalID, a.Reason, b.Reason, strings.Join(a.Chain, "/"), strings.Join(b.Chain, "/")) < 0
+    })
+}
+
+func sortDryRunResources(resources []DryRunResource) {
+    sort.SliceStable(resources, func(i, j int) bool {
+        a, b := resources[i], resources[j]
+        return compare(a.Region, b.Region, a.AwsNukeType, b.AwsNukeType, a.ID, b.ID, a.Message, b.Message) < 0
+    })
+}
+
+// Compare pairs of values in turn, returning at the first which differ
+func compare(pairs ...string) int {
+    for i := 0; i+1 < len(pairs); i += 2 {
+        if c := strings.Compare(pairs[i], pairs[i+1]); c != 0 {
+            return c
+        }
+    }
+    return 0
+}
+
+// Write the report as indented JSON
+func (r Report) WriteJSON(path string) error {
+    data, err := json.MarshalIndent(r, "", "  ")
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Write the report as Markdown tables, for reading in a pull request or pipeline summary
+func (r Report) WriteMarkdown(path string) error {
+    var b strings.Builder
+    fmt.Fprintf(&b, "# aws-nuke-shield report\n\n")
+    fmt.Fprintf(&b, "Account `%s`, base config `%s`, generated config `%s`\n", r.Account, r.BaseConfig, r.GeneratedConfig)
+    if r.Error != "" {
+        fmt.Fprintf(&b, "\n**The run stopped before aws-nuke removed anything, so this report only covers what was done up to then:** %s\n", r.Error)
+    }
+
+    fmt.Fprintf(&b, "\n## Stacks (%d)\n\n", len(r.Stacks))
+    fmt.Fprintf(&b, "| Region | Stack | Status | Reason | Detail |\n|---|---|---|---|---|\n")
+    for _, stack := range r.Stacks {
+        fmt.Fprintf(&b, "| %s | %s | %s | %s | %s |\n", cell(stack.Region), cell(stack.Name), cell(stack.Status), cell(stack.Reason), cell(stack.Detail))
+    }
+
+    fmt.Fprintf(&b, "\n## Resources (%d)\n\n", len(r.Resources))
+    writeResources(&b, r.Resources)
+
+    fmt.Fprintf(&b, "\n## Unmapped resources (%d)\n\n", len(r.Unmapped))
+    fmt.Fprintf(&b, "These could not be mapped to an aws-nuke type, so have no filter.\n\n")
+    writeResources(&b, r.Unmapped)
+
+    fmt.Fprintf(&b, "\n## Other filters (%d)\n\n", len(r.Filters))
+    fmt.Fprintf(&b, "| aws-nuke type | Filter | Reason | Detail |\n|---|---|---|---|\n")
+    for _, filter := range r.Filters {
+        fmt.Fprintf(&b, "| %s | %s | %s | %s |\n", cell(filter.AwsNukeType), cell(filter.Filter.Describe()), cell(filter.Reason), cell(filter.Detail))
+    }
+
+    fmt.Fprintf(&b, "\n## Excluded resource types (%d)\n\n", len(r.ResourceTypes))
+    for _, resourceType := range r.ResourceTypes {
+        fmt.Fprintf(&b, "- %s\n", resourceType)
+    }
+
+    fmt.Fprintf(&b, "\n## Discovery errors (%d)\n\n", len(r.DiscoveryErrors))
+    for _, discoveryError := range r.DiscoveryErrors {
+        if discoveryError.Stack == "" {
+            fmt.Fprintf(&b, "- region %s: %s\n", discoveryError.Region, discoveryError.Error)
+        } else {
+            fmt.Fprintf(&b, "- region %s, stack %s: %s\n", discoveryError.Region, discoveryError.Stack, discoveryError.Error)
+        }
+    }
+
+    fmt.Fprintf(&b, "\n## Safety interlock\n\n")
+    if r.Interlock == nil {
+        fmt.Fprintf(&b, "The run stopped before the dry run, so nothing was checked.\n")
+        return os.WriteFile(path, []byte(b.String()), 0644)
+    }
+    verdict := "passed"
+    if !r.Interlock.Passed {
+        verdict = "**failed**"
+    }
+    fmt.Fprintf(&b, "The check %s: %d filtered, %d would be removed, %d in an unknown state, %d not seen in the dry run, %d of types aws-nuke does not scan, %d companions matching nothing.\n\n",
+        verdict, r.Interlock.Filtered, r.Interlock.WouldRemove, r.Interlock.Unknown, r.Interlock.NotSeen, r.Interlock.NotScanned, r.Interlock.NoneFound)
+    fmt.Fprintf(&b, "| aws-nuke type | Identity | Filter | Outcome | Source |\n|---|---|---|---|---|\n")
+    for _, result := range r.Interlock.Results {
+        fmt.Fprintf(&b, "| %s | %s | %s | %s | %s |\n", cell(result.AwsNukeType), cell(result.Identity), cell(result.Filter.Describe()), cell(result.Outcome), cell(result.Source))
+    }
+    if len(r.Interlock.TagMisses) != 0 {
+        fmt.Fprintf(&b, "\nResources carrying a preserved tag which would be removed:\n\n")
+        for _, miss := range r.Interlock.TagMisses {
+            fmt.Fprintf(&b, "- region %s, %s %s\n", miss.Region, miss.AwsNukeType, miss.ID)
+        }
+    }
+    if len(r.Interlock.UnknownStates) != 0 {
+        fmt.Fprintf(&b, "\nResources for which aws-nuke printed a message Shield does not recognise:\n\n")
+        for _, unknown := range r.Interlock.UnknownStates {
+            fmt.Fprintf(&b, "- region %s, %s %s: %s\n", unknown.Region, unknown.AwsNukeType, unknown.ID, unknown.Message)
+        }
+    }
+    return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+func writeResources(b *strings.Builder, resources []Resource) {
+    fmt.Fprintf(b, "| Region | CFN type | Physical ID | aws-nuke type | Filter | Reason | Detail |\n|---|---|---|---|---|---|---|\n")
+    for _, resource := range resources {
+        detail := resource.Detail
+        if len(resource.Chain) != 0 {
+            detail = strings.Join(append(append([]string{}, resource.Chain...), resource.LogicalID), " > ")
+        }
+        filter := ""
+        if resource.Filter != nil {
+            filter = resource.Filter.Describe()
+        }
+        fmt.Fprintf(b, "| %s | %s | %s | %s | %s | %s | %s |\n", cell(resource.Region), cell(resource.CFNType), cell(resource.PhysicalID), cell(resource.AwsNukeType), cell(filter), cell(resource.Reason), cell(detail))
+    }
+}
+
+// Escape a value for a Markdown table cell
+func cell(value string) string {
+    value = strings.ReplaceAll(value, "|", "\\|")
+    return strings.ReplaceAll(value, "\n", " ")
+}
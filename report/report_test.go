@@ -0,0 +1,99 @@
+package report
+
+import (
+	"awsnukeshield/interlock"
+	"awsnukeshield/nukeconfig"
+	"awsnukeshield/nukeoutput"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAddExpectations(t *testing.T) {
+    r := New("123456789012", "nuke-config.yml", "nuke-config.yml-shield-generated")
+    r.AddResource(Resource{Region: "eu-west-1", CFNType: "AWS::IAM::Role", PhysicalID: "app-Role", Reason: ReasonStack})
+    r.AddResource(Resource{Region: "eu-west-1", CFNType: "AWS::Custom::Thing", PhysicalID: "thing-1", Reason: ReasonStack})
+    r.AddExpectations([]interlock.Expectation{
+        {AwsNukeType: "IAMRole", CFNType: "AWS::IAM::Role", Identity: "app-Role", Filter: nukeconfig.Filter{Value: "app-Role"}},
+        {AwsNukeType: "IAMRolePolicy", Identity: "app-Role", Filter: nukeconfig.Filter{Property: "role:RoleName", Value: "app-Role"}, Source: "companion of IAMRole app-Role"},
+    })
+
+    if len(r.Resources) != 1 || r.Resources[0].AwsNukeType != "IAMRole" || r.Resources[0].Filter == nil || r.Resources[0].Filter.Value != "app-Role" {
+        t.Errorf("resources = %+v, want app-Role mapped to IAMRole", r.Resources)
+    }
+    if len(r.Unmapped) != 1 || r.Unmapped[0].PhysicalID != "thing-1" {
+        t.Errorf("unmapped = %+v, want thing-1", r.Unmapped)
+    }
+    if len(r.Filters) != 1 || r.Filters[0].Reason != ReasonCompanion || r.Filters[0].AwsNukeType != "IAMRolePolicy" {
+        t.Errorf("filters = %+v, want the IAMRolePolicy companion", r.Filters)
+    }
+}
+
+func TestAddInterlock(t *testing.T) {
+    r := New("123456789012", "nuke-config.yml", "nuke-config.yml-shield-generated")
+    r.AddInterlock(interlock.Report{
+        Results: []interlock.Result{
+            {Expectation: interlock.Expectation{AwsNukeType: "S3Bucket", Identity: "app-logs"}, Outcome: interlock.OutcomeFiltered},
+            {Expectation: interlock.Expectation{AwsNukeType: "DynamoDBTable", Identity: "app-table"}, Outcome: interlock.OutcomeNotSeen},
+            {Expectation: interlock.Expectation{AwsNukeType: "ECSCluster", Identity: "app-cluster"}, Outcome: interlock.OutcomeWouldRemove},
+        },
+        TagMisses:   []nukeoutput.Event{{Region: "eu-west-1", ResourceType: "EC2Instance", ID: "i-0e5f6a7b"}},
+        Filtered:    1,
+        WouldRemove: 1,
+        NotSeen:     1,
+    }, false)
+    r.Sort()
+
+    if r.Interlock == nil || r.Interlock.Passed || r.Interlock.Filtered != 1 || r.Interlock.NotSeen != 1 {
+        t.Fatalf("interlock = %+v, want a failed check with the counts", r.Interlock)
+    }
+    var outcomes []string
+    for _, result := range r.Interlock.Results {
+        outcomes = append(outcomes, result.AwsNukeType+" "+result.Outcome)
+    }
+    if strings.Join(outcomes, ", ") != "DynamoDBTable not seen, ECSCluster would remove" {
+        t.Errorf("results = %v, want only those not confirmed filtered, sorted by outcome", outcomes)
+    }
+    if len(r.Interlock.TagMisses) != 1 || r.Interlock.TagMisses[0].ID != "i-0e5f6a7b" {
+        t.Errorf("tag misses = %+v, want i-0e5f6a7b", r.Interlock.TagMisses)
+    }
+}
+
+func TestPartialReport(t *testing.T) {
+    dir := t.TempDir()
+    r := New("123456789012", "nuke-config.yml", "nuke-config.yml-shield-generated")
+    r.AddResource(Resource{Region: "eu-west-1", CFNType: "AWS::Custom::Thing", PhysicalID: "thing-1", Reason: ReasonStack})
+    // Stopping on an ambiguous mapping, before any filter was emitted
+    r.AddExpectations(nil)
+    r.Error = "mapping incomplete: [AWS::Custom::Thing] have no mapping"
+
+    jsonFile, markdownFile := filepath.Join(dir, "report.json"), filepath.Join(dir, "report.md")
+    if err := r.WriteJSON(jsonFile); err != nil {
+        t.Fatal(err)
+    }
+    if err := r.WriteMarkdown(markdownFile); err != nil {
+        t.Fatal(err)
+    }
+
+    data, err := os.ReadFile(jsonFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var written Report
+    if err := json.Unmarshal(data, &written); err != nil {
+        t.Fatal(err)
+    }
+    if written.Error != r.Error || len(written.Unmapped) != 1 || written.Resources == nil {
+        t.Errorf("written report = %+v, want the error, one unmapped resource and empty lists", written)
+    }
+
+    data, err = os.ReadFile(markdownFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !strings.Contains(string(data), "The run stopped") || !strings.Contains(string(data), r.Error) {
+        t.Errorf("the Markdown report does not say where the run stopped:\n%s", data)
+    }
+}
@@ -0,0 +1,196 @@
+package resources
+
+import (
+	"awsnukeshield/helpers"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The parts of an ARN, arn:<partition>:<service>:<region>:<account>:<resource>
+type ARN struct {
+    Partition string
+    Service   string
+    Region    string
+    Account   string
+    // The resource type, e.g. role in role/path/name. Empty for services whose ARNs carry only an ID, such as S3 buckets
+    ResourceType string
+    // The resource after its type, e.g. path/name in role/path/name
+    Resource     string
+}
+
+func ParseARN(arn string) (ARN, error) {
+    parts := strings.SplitN(arn, ":", 6)
+    if len(parts) != 6 || parts[0] != "arn" {
+        return ARN{}, fmt.Errorf("%q is not an ARN", arn)
+    }
+    // Some services, such as API Gateway, start the resource with a /
+    parsed := ARN{Partition: parts[1], Service: parts[2], Region: parts[3], Account: parts[4], Resource: strings.TrimPrefix(parts[5], "/")}
+
+    // The resource type is separated from the resource by the first / or :, whichever comes first
+    if index := strings.IndexAny(parsed.Resource, "/:"); index != -1 {
+        parsed.ResourceType = parsed.Resource[:index]
+        parsed.Resource = parsed.Resource[index+1:]
+    }
+    return parsed, nil
+}
+
+// How to recognise the CFN type of an ARN, and recover the physical ID CFN would report for the resource.
+// PhysicalID returns an empty string for ARNs of the service and resource type which are not of the CFN type, such as sub-resources
+type arnRule struct {
+    Service      string
+    ResourceType string
+    CFNType      string
+    PhysicalID   func(arn string, parsed ARN) string
+}
+
+func arnResource(arn string, parsed ARN) string {
+    return parsed.Resource
+}
+
+func arnWhole(arn string, parsed ARN) string {
+    return arn
+}
+
+func arnLastSegment(arn string, parsed ARN) string {
+    segments := strings.Split(parsed.Resource, "/")
+    return segments[len(segments)-1]
+}
+
+func arnFirstSegment(arn string, parsed ARN) string {
+    return strings.Split(parsed.Resource, "/")[0]
+}
+
+// The domain of the service endpoints of each partition other than aws and aws-us-gov
+var dnsSuffixes = map[string]string{
+    "aws-cn":    "amazonaws.com.cn",
+    "aws-iso":   "c2s.ic.gov",
+    "aws-iso-b": "sc2s.sgov.gov",
+}
+
+func dnsSuffix(partition string) string {
+    if suffix, ok := dnsSuffixes[partition]; ok {
+        return suffix
+    }
+    return "amazonaws.com"
+}
+
+// Services whose resources are global, which the Resource Groups Tagging API only returns in us-east-1
+var globalServices = []string{"iam", "cloudfront"}
+
+// ARNs of the resource types which are commonly tagged, by service and resource type
+var arnRules = []arnRule{
+    {"s3", "", "AWS::S3::Bucket", arnResource},
+    {"iam", "role", "AWS::IAM::Role", arnLastSegment},
+    {"iam", "user", "AWS::IAM::User", arnLastSegment},
+    {"iam", "policy", "AWS::IAM::ManagedPolicy", arnWhole},
+    {"iam", "instance-profile", "AWS::IAM::InstanceProfile", arnLastSegment},
+    {"iam", "oidc-provider", "AWS::IAM::OIDCProvider", arnWhole},
+    {"iam", "saml-provider", "AWS::IAM::SAMLProvider", arnWhole},
+    {"sns", "", "AWS::SNS::Topic", arnWhole},
+    {"sqs", "", "AWS::SQS::Queue", func(arn string, parsed ARN) string {
+        // CFN reports the queue URL
+        return fmt.Sprintf("https://sqs.%s.%s/%s/%s", parsed.Region, dnsSuffix(parsed.Partition), parsed.Account, parsed.Resource)
+    }},
+    {"lambda", "function", "AWS::Lambda::Function", func(arn string, parsed ARN) string {
+        // Without any version or alias
+        return strings.Split(parsed.Resource, ":")[0]
+    }},
+    {"logs", "log-group", "AWS::Logs::LogGroup", func(arn string, parsed ARN) string {
+        return strings.TrimSuffix(parsed.Resource, ":*")
+    }},
+    {"dynamodb", "table", "AWS::DynamoDB::Table", arnFirstSegment},
+    {"kinesis", "stream", "AWS::Kinesis::Stream", arnResource},
+    {"firehose", "deliverystream", "AWS::KinesisFirehose::DeliveryStream", arnResource},
+    {"ec2", "instance", "AWS::EC2::Instance", arnResource},
+    {"ec2", "vpc", "AWS::EC2::VPC", arnResource},
+    {"ec2", "subnet", "AWS::EC2::Subnet", arnResource},
+    {"ec2", "security-group", "AWS::EC2::SecurityGroup", arnResource},
+    {"ec2", "volume", "AWS::EC2::Volume", arnResource},
+    {"ec2", "internet-gateway", "AWS::EC2::InternetGateway", arnResource},
+    {"ec2", "natgateway", "AWS::EC2::NatGateway", arnResource},
+    {"ec2", "route-table", "AWS::EC2::RouteTable", arnResource},
+    {"ec2", "network-acl", "AWS::EC2::NetworkAcl", arnResource},
+    {"ec2", "network-interface", "AWS::EC2::NetworkInterface", arnResource},
+    {"ec2", "launch-template", "AWS::EC2::LaunchTemplate", arnResource},
+    {"ec2", "vpc-endpoint", "AWS::EC2::VPCEndpoint", arnResource},
+    {"ec2", "transit-gateway", "AWS::EC2::TransitGateway", arnResource},
+    {"ecs", "cluster", "AWS::ECS::Cluster", arnResource},
+    {"ecs", "service", "AWS::ECS::Service", arnWhole},
+    {"ecr", "repository", "AWS::ECR::Repository", arnResource},
+    {"eks", "cluster", "AWS::EKS::Cluster", arnResource},
+    {"kms", "key", "AWS::KMS::Key", arnResource},
+    {"rds", "db", "AWS::RDS::DBInstance", arnResource},
+    {"rds", "cluster", "AWS::RDS::DBCluster", arnResource},
+    {"rds", "subgrp", "AWS::RDS::DBSubnetGroup", arnResource},
+    {"secretsmanager", "secret", "AWS::SecretsManager::Secret", arnWhole},
+    {"states", "stateMachine", "AWS::StepFunctions::StateMachine", arnWhole},
+    {"elasticloadbalancing", "loadbalancer", "AWS::ElasticLoadBalancingV2::LoadBalancer", func(arn string, parsed ARN) string {
+        if strings.HasPrefix(parsed.Resource, "app/") || strings.HasPrefix(parsed.Resource, "net/") || strings.HasPrefix(parsed.Resource, "gwy/") {
+            return arn
+        }
+        return ""
+    }},
+    {"elasticloadbalancing", "loadbalancer", "AWS::ElasticLoadBalancing::LoadBalancer", func(arn string, parsed ARN) string {
+        if strings.Contains(parsed.Resource, "/") {
+            return ""
+        }
+        return parsed.Resource
+    }},
+    {"elasticloadbalancing", "targetgroup", "AWS::ElasticLoadBalancingV2::TargetGroup", arnWhole},
+    {"cloudformation", "stack", "AWS::CloudFormation::Stack", arnFirstSegment},
+    {"events", "rule", "AWS::Events::Rule", func(arn string, parsed ARN) string {
+        // Rules on a custom event bus are reported as <bus>|<rule>
+        return strings.Replace(parsed.Resource, "/", "|", 1)
+    }},
+    {"cloudwatch", "alarm", "AWS::CloudWatch::Alarm", arnResource},
+    {"codebuild", "project", "AWS::CodeBuild::Project", arnResource},
+    {"apigateway", "restapis", "AWS::ApiGateway::RestApi", func(arn string, parsed ARN) string {
+        // Not the stages and other parts of the API
+        if strings.Contains(parsed.Resource, "/") {
+            return ""
+        }
+        return parsed.Resource
+    }},
+    {"es", "domain", "AWS::Elasticsearch::Domain", arnResource},
+    {"elasticfilesystem", "file-system", "AWS::EFS::FileSystem", arnResource},
+    {"elasticache", "cluster", "AWS::ElastiCache::CacheCluster", arnResource},
+    {"cognito-idp", "userpool", "AWS::Cognito::UserPool", arnResource},
+    {"acm", "certificate", "AWS::CertificateManager::Certificate", arnWhole},
+    {"cloudfront", "distribution", "AWS::CloudFront::Distribution", arnResource},
+}
+
+// The CFN types of the resources ResourceFromARN recognises, which are those GetResourcesWithAllTags can resolve. Resources
+// of global services are only returned in us-east-1, so their types are left out unless globalScanned
+func ARNTypes(globalScanned bool) []string {
+    var cfnTypes []string
+    for _, rule := range arnRules {
+        if !globalScanned && helpers.FindItemExact(globalServices, rule.Service) != -1 {
+            continue
+        }
+        if helpers.FindItemExact(cfnTypes, rule.CFNType) == -1 {
+            cfnTypes = append(cfnTypes, rule.CFNType)
+        }
+    }
+    sort.Strings(cfnTypes)
+    return cfnTypes
+}
+
+// Return the CFN type of the resource with the given ARN, and the physical ID CFN would report for it. found is false for ARNs
+// of resource types Shield cannot recognise
+func ResourceFromARN(arn string) (cfnType string, physicalID string, found bool) {
+    parsed, err := ParseARN(arn)
+    if err != nil {
+        return "", "", false
+    }
+
+    for _, rule := range arnRules {
+        if rule.Service != parsed.Service || rule.ResourceType != parsed.ResourceType {
+            continue
+        }
+        if physicalID := rule.PhysicalID(arn, parsed); physicalID != "" {
+            return rule.CFNType, physicalID, true
+        }
+    }
+    return "", "", false
+}
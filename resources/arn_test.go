@@ -0,0 +1,168 @@
+package resources
+
+import (
+	"awsnukeshield/helpers"
+	"testing"
+)
+
+func TestParseARN(t *testing.T) {
+    tests := []struct {
+        arn     string
+        parsed  ARN
+        invalid bool
+    }{
+        {arn: "arn:aws:s3:::my-bucket", parsed: ARN{Partition: "aws", Service: "s3", Resource: "my-bucket"}},
+        {arn: "arn:aws:iam::123456789012:role/service-role/my-role", parsed: ARN{Partition: "aws", Service: "iam", Account: "123456789012", ResourceType: "role", Resource: "service-role/my-role"}},
+        {arn: "arn:aws-us-gov:lambda:us-gov-west-1:123456789012:function:my-function:live", parsed: ARN{Partition: "aws-us-gov", Service: "lambda", Region: "us-gov-west-1", Account: "123456789012", ResourceType: "function", Resource: "my-function:live"}},
+        {arn: "arn:aws:apigateway:eu-west-1::/restapis/a1b2c3d4e5", parsed: ARN{Partition: "aws", Service: "apigateway", Region: "eu-west-1", ResourceType: "restapis", Resource: "a1b2c3d4e5"}},
+        {arn: "arn:aws:sns:eu-west-1:123456789012:alerts", parsed: ARN{Partition: "aws", Service: "sns", Region: "eu-west-1", Account: "123456789012", Resource: "alerts"}},
+        {arn: "my-bucket", invalid: true},
+        {arn: "arn:aws:s3", invalid: true},
+        {arn: "urn:aws:s3:::my-bucket", invalid: true},
+    }
+
+    for _, test := range tests {
+        parsed, err := ParseARN(test.arn)
+        if test.invalid {
+            if err == nil {
+                t.Errorf("ParseARN(%q) = %+v, want an error", test.arn, parsed)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("ParseARN(%q): %v", test.arn, err)
+        } else if parsed != test.parsed {
+            t.Errorf("ParseARN(%q) = %+v, want %+v", test.arn, parsed, test.parsed)
+        }
+    }
+}
+
+// One ARN for every rule, with the physical ID CFN reports for the resource
+func TestResourceFromARN(t *testing.T) {
+    tests := []struct {
+        arn        string
+        cfnType    string
+        physicalID string
+    }{
+        {"arn:aws:s3:::my-bucket", "AWS::S3::Bucket", "my-bucket"},
+        {"arn:aws:iam::123456789012:role/service-role/my-role", "AWS::IAM::Role", "my-role"},
+        {"arn:aws:iam::123456789012:user/my-user", "AWS::IAM::User", "my-user"},
+        {"arn:aws:iam::123456789012:policy/my-policy", "AWS::IAM::ManagedPolicy", "arn:aws:iam::123456789012:policy/my-policy"},
+        {"arn:aws:iam::123456789012:instance-profile/my-profile", "AWS::IAM::InstanceProfile", "my-profile"},
+        {"arn:aws:iam::123456789012:oidc-provider/token.actions.githubusercontent.com", "AWS::IAM::OIDCProvider", "arn:aws:iam::123456789012:oidc-provider/token.actions.githubusercontent.com"},
+        {"arn:aws:iam::123456789012:saml-provider/my-idp", "AWS::IAM::SAMLProvider", "arn:aws:iam::123456789012:saml-provider/my-idp"},
+        {"arn:aws:sns:eu-west-1:123456789012:alerts", "AWS::SNS::Topic", "arn:aws:sns:eu-west-1:123456789012:alerts"},
+        {"arn:aws:sqs:eu-west-1:123456789012:jobs", "AWS::SQS::Queue", "https://sqs.eu-west-1.amazonaws.com/123456789012/jobs"},
+        {"arn:aws:lambda:eu-west-1:123456789012:function:my-function:live", "AWS::Lambda::Function", "my-function"},
+        {"arn:aws:logs:eu-west-1:123456789012:log-group:/aws/lambda/my-function:*", "AWS::Logs::LogGroup", "/aws/lambda/my-function"},
+        {"arn:aws:dynamodb:eu-west-1:123456789012:table/my-table", "AWS::DynamoDB::Table", "my-table"},
+        {"arn:aws:kinesis:eu-west-1:123456789012:stream/my-stream", "AWS::Kinesis::Stream", "my-stream"},
+        {"arn:aws:firehose:eu-west-1:123456789012:deliverystream/my-stream", "AWS::KinesisFirehose::DeliveryStream", "my-stream"},
+        {"arn:aws:ec2:eu-west-1:123456789012:instance/i-0a1b2c3d4e5f67890", "AWS::EC2::Instance", "i-0a1b2c3d4e5f67890"},
+        {"arn:aws:ec2:eu-west-1:123456789012:vpc/vpc-0c3d9a4e", "AWS::EC2::VPC", "vpc-0c3d9a4e"},
+        {"arn:aws:ec2:eu-west-1:123456789012:subnet/subnet-0a1b2c3d", "AWS::EC2::Subnet", "subnet-0a1b2c3d"},
+        {"arn:aws:ec2:eu-west-1:123456789012:security-group/sg-0a1b2c3d", "AWS::EC2::SecurityGroup", "sg-0a1b2c3d"},
+        {"arn:aws:ec2:eu-west-1:123456789012:volume/vol-0a1b2c3d", "AWS::EC2::Volume", "vol-0a1b2c3d"},
+        {"arn:aws:ec2:eu-west-1:123456789012:internet-gateway/igw-0a1b2c3d", "AWS::EC2::InternetGateway", "igw-0a1b2c3d"},
+        {"arn:aws:ec2:eu-west-1:123456789012:natgateway/nat-0a1b2c3d", "AWS::EC2::NatGateway", "nat-0a1b2c3d"},
+        {"arn:aws:ec2:eu-west-1:123456789012:route-table/rtb-0a1b2c3d", "AWS::EC2::RouteTable", "rtb-0a1b2c3d"},
+        {"arn:aws:ec2:eu-west-1:123456789012:network-acl/acl-0a1b2c3d", "AWS::EC2::NetworkAcl", "acl-0a1b2c3d"},
+        {"arn:aws:ec2:eu-west-1:123456789012:network-interface/eni-0a1b2c3d", "AWS::EC2::NetworkInterface", "eni-0a1b2c3d"},
+        {"arn:aws:ec2:eu-west-1:123456789012:launch-template/lt-0a1b2c3d", "AWS::EC2::LaunchTemplate", "lt-0a1b2c3d"},
+        {"arn:aws:ec2:eu-west-1:123456789012:vpc-endpoint/vpce-0a1b2c3d", "AWS::EC2::VPCEndpoint", "vpce-0a1b2c3d"},
+        {"arn:aws:ec2:eu-west-1:123456789012:transit-gateway/tgw-0a1b2c3d", "AWS::EC2::TransitGateway", "tgw-0a1b2c3d"},
+        {"arn:aws:ecs:eu-west-1:123456789012:cluster/my-cluster", "AWS::ECS::Cluster", "my-cluster"},
+        {"arn:aws:ecs:eu-west-1:123456789012:service/my-cluster/my-service", "AWS::ECS::Service", "arn:aws:ecs:eu-west-1:123456789012:service/my-cluster/my-service"},
+        {"arn:aws:ecr:eu-west-1:123456789012:repository/my-repo", "AWS::ECR::Repository", "my-repo"},
+        {"arn:aws:eks:eu-west-1:123456789012:cluster/my-cluster", "AWS::EKS::Cluster", "my-cluster"},
+        {"arn:aws:kms:eu-west-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab", "AWS::KMS::Key", "1234abcd-12ab-34cd-56ef-1234567890ab"},
+        {"arn:aws:rds:eu-west-1:123456789012:db:my-db", "AWS::RDS::DBInstance", "my-db"},
+        {"arn:aws:rds:eu-west-1:123456789012:cluster:my-cluster", "AWS::RDS::DBCluster", "my-cluster"},
+        {"arn:aws:rds:eu-west-1:123456789012:subgrp:my-subnet-group", "AWS::RDS::DBSubnetGroup", "my-subnet-group"},
+        {"arn:aws:secretsmanager:eu-west-1:123456789012:secret:my-secret-AbCdEf", "AWS::SecretsManager::Secret", "arn:aws:secretsmanager:eu-west-1:123456789012:secret:my-secret-AbCdEf"},
+        {"arn:aws:states:eu-west-1:123456789012:stateMachine:my-machine", "AWS::StepFunctions::StateMachine", "arn:aws:states:eu-west-1:123456789012:stateMachine:my-machine"},
+        {"arn:aws:elasticloadbalancing:eu-west-1:123456789012:loadbalancer/app/my-alb/50dc6c495c0c9188", "AWS::ElasticLoadBalancingV2::LoadBalancer", "arn:aws:elasticloadbalancing:eu-west-1:123456789012:loadbalancer/app/my-alb/50dc6c495c0c9188"},
+        {"arn:aws:elasticloadbalancing:eu-west-1:123456789012:loadbalancer/my-classic-elb", "AWS::ElasticLoadBalancing::LoadBalancer", "my-classic-elb"},
+        {"arn:aws:elasticloadbalancing:eu-west-1:123456789012:targetgroup/my-tg/73e2d6bc24d8a067", "AWS::ElasticLoadBalancingV2::TargetGroup", "arn:aws:elasticloadbalancing:eu-west-1:123456789012:targetgroup/my-tg/73e2d6bc24d8a067"},
+        {"arn:aws:cloudformation:eu-west-1:123456789012:stack/my-stack/1a2b3c4d-5e6f-7a8b-9c0d-1e2f3a4b5c6d", "AWS::CloudFormation::Stack", "my-stack"},
+        {"arn:aws:events:eu-west-1:123456789012:rule/my-bus/my-rule", "AWS::Events::Rule", "my-bus|my-rule"},
+        {"arn:aws:cloudwatch:eu-west-1:123456789012:alarm:my-alarm", "AWS::CloudWatch::Alarm", "my-alarm"},
+        {"arn:aws:codebuild:eu-west-1:123456789012:project/my-project", "AWS::CodeBuild::Project", "my-project"},
+        {"arn:aws:apigateway:eu-west-1::/restapis/a1b2c3d4e5", "AWS::ApiGateway::RestApi", "a1b2c3d4e5"},
+        {"arn:aws:es:eu-west-1:123456789012:domain/my-domain", "AWS::Elasticsearch::Domain", "my-domain"},
+        {"arn:aws:elasticfilesystem:eu-west-1:123456789012:file-system/fs-0a1b2c3d", "AWS::EFS::FileSystem", "fs-0a1b2c3d"},
+        {"arn:aws:elasticache:eu-west-1:123456789012:cluster:my-cache", "AWS::ElastiCache::CacheCluster", "my-cache"},
+        {"arn:aws:cognito-idp:eu-west-1:123456789012:userpool/eu-west-1_AbCdEfGhI", "AWS::Cognito::UserPool", "eu-west-1_AbCdEfGhI"},
+        {"arn:aws:acm:eu-west-1:123456789012:certificate/1a2b3c4d-5e6f-7a8b-9c0d-1e2f3a4b5c6d", "AWS::CertificateManager::Certificate", "arn:aws:acm:eu-west-1:123456789012:certificate/1a2b3c4d-5e6f-7a8b-9c0d-1e2f3a4b5c6d"},
+        {"arn:aws:cloudfront::123456789012:distribution/E1A2B3C4D5E6F7", "AWS::CloudFront::Distribution", "E1A2B3C4D5E6F7"},
+        // Also on the default event bus
+        {"arn:aws:events:eu-west-1:123456789012:rule/my-rule", "AWS::Events::Rule", "my-rule"},
+        // Queue URLs are on the domain of the partition
+        {"arn:aws-cn:sqs:cn-north-1:123456789012:jobs", "AWS::SQS::Queue", "https://sqs.cn-north-1.amazonaws.com.cn/123456789012/jobs"},
+        {"arn:aws-us-gov:sqs:us-gov-west-1:123456789012:jobs", "AWS::SQS::Queue", "https://sqs.us-gov-west-1.amazonaws.com/123456789012/jobs"},
+        {"arn:aws-iso:sqs:us-iso-east-1:123456789012:jobs", "AWS::SQS::Queue", "https://sqs.us-iso-east-1.c2s.ic.gov/123456789012/jobs"},
+    }
+
+    tested := make(map[string]bool)
+    for _, test := range tests {
+        tested[test.cfnType] = true
+        cfnType, physicalID, found := ResourceFromARN(test.arn)
+        if !found || cfnType != test.cfnType || physicalID != test.physicalID {
+            t.Errorf("ResourceFromARN(%q) = %q, %q, %v, want %q, %q, true", test.arn, cfnType, physicalID, found, test.cfnType, test.physicalID)
+        }
+    }
+    for _, rule := range arnRules {
+        if !tested[rule.CFNType] {
+            t.Errorf("the ARN rule for %s is not tested", rule.CFNType)
+        }
+    }
+}
+
+func TestResourceFromARNUnrecognised(t *testing.T) {
+    for _, arn := range []string{
+        "not-an-arn",
+        // A service Shield does not know
+        "arn:aws:glue:eu-west-1:123456789012:database/my-database",
+        // A resource type of a known service
+        "arn:aws:ec2:eu-west-1:123456789012:snapshot/snap-0a1b2c3d",
+        // Sub-resources of known resource types
+        "arn:aws:s3:::my-bucket/key",
+        "arn:aws:sns:eu-west-1:123456789012:alerts:0b1c2d3e-4f5a-6b7c-8d9e-0f1a2b3c4d5e",
+        "arn:aws:apigateway:eu-west-1::/restapis/a1b2c3d4e5/stages/prod",
+        "arn:aws:elasticloadbalancing:eu-west-1:123456789012:loadbalancer/unknown/my-lb/50dc6c495c0c9188",
+    } {
+        if cfnType, physicalID, found := ResourceFromARN(arn); found {
+            t.Errorf("ResourceFromARN(%q) = %q, %q, want it unrecognised", arn, cfnType, physicalID)
+        }
+    }
+}
+
+func TestARNTypes(t *testing.T) {
+    tests := []struct {
+        globalScanned bool
+        cfnType       string
+        included      bool
+    }{
+        {true, "AWS::S3::Bucket", true},
+        {false, "AWS::S3::Bucket", true},
+        {true, "AWS::IAM::Role", true},
+        // Global resources are only returned in us-east-1
+        {false, "AWS::IAM::Role", false},
+        {false, "AWS::CloudFront::Distribution", false},
+        // Not recognised from its ARN at all
+        {true, "AWS::Route53::HostedZone", false},
+    }
+    for _, test := range tests {
+        cfnTypes := ARNTypes(test.globalScanned)
+        if included := helpers.FindItemExact(cfnTypes, test.cfnType) != -1; included != test.included {
+            t.Errorf("ARNTypes(%v) includes %s = %v, want %v", test.globalScanned, test.cfnType, included, test.included)
+        }
+    }
+    // Two rules recognise load balancers, each type is listed once
+    all := ARNTypes(true)
+    for i := 1; i < len(all); i++ {
+        if all[i] == all[i-1] {
+            t.Errorf("%s is listed twice", all[i])
+        }
+    }
+}
@@ -1,19 +1,86 @@
 package resources
 
 import (
+	"awsnukeshield/helpers"
 	"context"
 	"fmt"
-	"regexp"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
 	"go.uber.org/zap"
 )
 
-func GetCFNStacksFromRegex(logger *zap.Logger, stackRegexes []string, region string) []string {
-    stackIds := []string{}
-    stackIdsFiltered := []string{}
+// Counts of what was inspected in a region, so that the extent of the discovery is visible in the output
+type RegionSummary struct {
+    Region           string
+    StacksChecked    int
+    StacksMatched    int
+    ResourcesChecked int
+    // Resources carrying all the tags, with -all-tags
+    TaggedResources  int
+    // Resources retained from deleted stacks, with -preserve-retained
+    RetainedResources int
+}
+
+// Stack statuses selected when -stack-statuses is not provided: every status in which the stack and its resources exist, excluding
+// deleted stacks and stacks whose creation failed
+var DefaultStackStatuses = []string{
+    string(types.StackStatusCreateInProgress),
+    string(types.StackStatusCreateComplete),
+    string(types.StackStatusRollbackInProgress),
+    string(types.StackStatusUpdateInProgress),
+    string(types.StackStatusUpdateCompleteCleanupInProgress),
+    string(types.StackStatusUpdateComplete),
+    string(types.StackStatusUpdateFailed),
+    string(types.StackStatusUpdateRollbackInProgress),
+    string(types.StackStatusUpdateRollbackFailed),
+    string(types.StackStatusUpdateRollbackCompleteCleanupInProgress),
+    string(types.StackStatusUpdateRollbackComplete),
+    string(types.StackStatusImportInProgress),
+    string(types.StackStatusImportComplete),
+    string(types.StackStatusImportRollbackInProgress),
+    string(types.StackStatusImportRollbackFailed),
+    string(types.StackStatusImportRollbackComplete),
+}
+
+// A CFN stack selected for preservation
+type Stack struct {
+    Name   string
+    ID     string
+    Status string
+    // Why the stack is preserved, e.g. the selector which matched it
+    Reason string
+}
+
+// Convert the provided stack statuses to the SDK type, rejecting any which CloudFormation does not know
+func ParseStackStatuses(statuses []string) ([]types.StackStatus, error) {
+    var parsed []types.StackStatus
+    for _, status := range statuses {
+        status = strings.ToUpper(strings.TrimSpace(status))
+        if status == "" {
+            continue
+        }
+        stackStatus := types.StackStatus(status)
+        if helpers.FindItemExact(stackStatusStrings(), status) == -1 {
+            return nil, fmt.Errorf("unknown stack status %q, valid statuses are: %s", status, strings.Join(stackStatusStrings(), ", "))
+        }
+        parsed = append(parsed, stackStatus)
+    }
+    return parsed, nil
+}
 
+func stackStatusStrings() []string {
+    var statuses []string
+    for _, status := range types.StackStatus("").Values() {
+        statuses = append(statuses, string(status))
+    }
+    return statuses
+}
+
+// Create a CFN client for the region
+func newCFNClient(region string) (*cloudformation.Client, error) {
 	// Using the SDK's default configuration, loading additional config
     // and credentials values from the environment variables, shared
     // credentials, and shared configuration files
@@ -21,75 +88,307 @@ func GetCFNStacksFromRegex(logger *zap.Logger, stackRegexes []string, region str
    		config.WithRegion(region),
    	)
     if err != nil {
-        logger.Error(fmt.Sprintf("unable to load SDK config, %v", err))
+        return nil, fmt.Errorf("unable to load SDK config, %w", err)
     }
 
     // Using the Config value, create the cloudformation client
-    svc := cloudformation.NewFromConfig(cfg)
+    return cloudformation.NewFromConfig(cfg), nil
+}
 
-    // Build the request with its input parameters
-    resp, err := svc.ListStacks(context.TODO(), &cloudformation.ListStacksInput{
-    })
+// Return the CFN stacks in the region which are in one of the given statuses and chosen by the selector, along with the total number of stacks checked.
+// The stacks are described when the selector needs more than their names and times.
+// Where several stacks share a name (e.g. a stack which was deleted and recreated), the live stack is used, falling back to the most recently created one.
+// An error is returned if the stacks of the region could not all be listed, in which case the returned stacks may be incomplete
+func GetCFNStacks(logger *zap.Logger, selector *StackSelector, stackStatuses []types.StackStatus, region string) ([]Stack, int, []DiscoveryError) {
+    stacksByName := make(map[string][]types.StackSummary)
+    var stackNames []string
+    stacksChecked := 0
+
+    svc, err := newCFNClient(region)
     if err != nil {
-        logger.Error(fmt.Sprintf("failed to get stacks, %v", err))
+        return nil, 0, []DiscoveryError{{Region: region, Err: err}}
     }
 
-    // Filter the CFN stacks based on provided regex
-    for i, stackSummary := range resp.StackSummaries {
-        
-        stackIds = append(stackIds, *stackSummary.StackName)
+    // Page through every stack in the region which is in one of the selected statuses
+    paginator := cloudformation.NewListStacksPaginator(svc, &cloudformation.ListStacksInput{
+        StackStatusFilter: stackStatuses,
+    })
+    var discoveryErrors []DiscoveryError
+    for paginator.HasMorePages() {
+        resp, err := paginator.NextPage(context.TODO())
+        if err != nil {
+            logger.Error(fmt.Sprintf("failed to get stacks, %v", err))
+            discoveryErrors = append(discoveryErrors, DiscoveryError{Region: region, Err: fmt.Errorf("failed to list stacks, %w", err)})
+            break
+        }
 
-        for _, stackRegex := range stackRegexes {
-            match, err := regexp.MatchString(stackRegex, stackIds[i])
+        for _, stackSummary := range resp.StackSummaries {
+            stacksChecked++
+            stackName := *stackSummary.StackName
+            if _, seen := stacksByName[stackName]; !seen {
+                stackNames = append(stackNames, stackName)
+            }
+            stacksByName[stackName] = append(stacksByName[stackName], stackSummary)
+        }
+    }
+
+    // Selecting by stack tags, termination protection, description or role needs the details which only DescribeStacks returns
+    describedStacks := make(map[string]types.Stack)
+    if selector.NeedsDetails() && len(discoveryErrors) == 0 {
+        describePaginator := cloudformation.NewDescribeStacksPaginator(svc, &cloudformation.DescribeStacksInput{})
+        for describePaginator.HasMorePages() {
+            resp, err := describePaginator.NextPage(context.TODO())
             if err != nil {
-                fmt.Println("Error ", err)
-            } else {
-                if match {
-                    logger.Debug(fmt.Sprintf("Regex match: %v\n", stackIdsFiltered))
-                    stackIdsFiltered = append(stackIdsFiltered, stackIds[i])
-                    break
-                }
+                logger.Error(fmt.Sprintf("failed to describe stacks, %v", err))
+                discoveryErrors = append(discoveryErrors, DiscoveryError{Region: region, Err: fmt.Errorf("failed to describe stacks, %w", err)})
+                break
+            }
+            for _, stack := range resp.Stacks {
+                describedStacks[*stack.StackId] = stack
             }
         }
     }
 
-    return stackIdsFiltered
+    // Filter the CFN stacks with the selector
+    stacksFiltered := []Stack{}
+    for _, stackName := range stackNames {
+        stackSummary := liveStack(stacksByName[stackName])
+        preserve, decision := selector.Select(stackDetails(stackSummary, describedStacks[*stackSummary.StackId]))
+        if preserve {
+            logger.Debug(fmt.Sprintf("Regex match: %v\n", stackName))
+            stacksFiltered = append(stacksFiltered, Stack{
+                Name:   stackName,
+                ID:     *stackSummary.StackId,
+                Status: string(stackSummary.StackStatus),
+                Reason: decision,
+            })
+        }
+
+        fmt.Printf("%s: %s (%s) - %s\n", region, stackName, stackSummary.StackStatus, decision)
+        if len(stacksByName[stackName]) > 1 {
+            fmt.Printf("%s: %s - %d stacks share this name, using %s\n", region, stackName, len(stacksByName[stackName]), *stackSummary.StackId)
+        }
+    }
+
+    return stacksFiltered, stacksChecked, discoveryErrors
 }
 
+// Combine what ListStacks and DescribeStacks return for a stack into what the selector needs. described is empty for stacks which were not described
+func stackDetails(stackSummary types.StackSummary, described types.Stack) StackDetails {
+    details := StackDetails{Name: *stackSummary.StackName, ID: *stackSummary.StackId}
+    if stackSummary.CreationTime != nil {
+        details.CreationTime = *stackSummary.CreationTime
+    }
+    if stackSummary.LastUpdatedTime != nil {
+        details.LastUpdatedTime = *stackSummary.LastUpdatedTime
+    }
+
+    if described.Description != nil {
+        details.Description = *described.Description
+    }
+    if described.EnableTerminationProtection != nil {
+        details.TerminationProtected = *described.EnableTerminationProtection
+    }
+    if described.RoleARN != nil {
+        details.RoleARN = *described.RoleARN
+    }
+    details.Tags = make(map[string]string)
+    for _, tag := range described.Tags {
+        details.Tags[*tag.Key] = *tag.Value
+    }
+    return details
+}
 
-func GetCFNStackChildren(logger *zap.Logger, stackName string, region string) map[string][]string {
-    resourcesByType := make(map[string][]string)
+// From stacks sharing a name, return the one which is not deleted, falling back to the most recently created
+func liveStack(stackSummaries []types.StackSummary) types.StackSummary {
+    chosen := stackSummaries[0]
+    for _, stackSummary := range stackSummaries[1:] {
+        chosenDeleted := chosen.StackStatus == types.StackStatusDeleteComplete
+        deleted := stackSummary.StackStatus == types.StackStatusDeleteComplete
+        if chosenDeleted && !deleted {
+            chosen = stackSummary
+        } else if chosenDeleted == deleted && stackSummary.CreationTime != nil && chosen.CreationTime != nil && stackSummary.CreationTime.After(*chosen.CreationTime) {
+            chosen = stackSummary
+        }
+    }
+    return chosen
+}
 
-	// Using the SDK's default configuration, loading additional config
-    // and credentials values from the environment variables, shared
-    // credentials, and shared configuration files
-    cfg, err := config.LoadDefaultConfig(context.TODO(),
-   		config.WithRegion(region),
-   	)
+
+// A child resource of a preserved CFN stack
+type StackResource struct {
+    Region       string
+    // Names of the stacks from the matched stack down to the (possibly nested) stack which owns the resource
+    Chain        []string
+    LogicalID    string
+    ResourceType string
+    PhysicalID   string
+}
+
+// Return the child resources of the stack, descending into nested stacks to any depth, along with the total number of child resources checked.
+// Nested stacks are themselves returned as children, identified by stack name rather than ARN, as that is what aws-nuke filters on.
+// An error is returned for the stack and for each nested stack whose resources could not all be listed
+func GetCFNStackChildren(logger *zap.Logger, stack Stack, region string) ([]StackResource, int, []DiscoveryError) {
+    svc, err := newCFNClient(region)
     if err != nil {
-        logger.Error(fmt.Sprintf("unable to load SDK config, %v", err))
+        return nil, 0, []DiscoveryError{{Region: region, Stack: stack.Name, Err: err}}
     }
 
-    // Using the Config value, create the cloudformation client
-    svc := cloudformation.NewFromConfig(cfg)
+    visited := map[string]bool{}
+    return getStackChildren(logger, svc, stack.ID, []string{stack.Name}, region, visited)
+}
+
+func getStackChildren(logger *zap.Logger, svc cloudformation.ListStackResourcesAPIClient, stackId string, chain []string, region string, visited map[string]bool) ([]StackResource, int, []DiscoveryError) {
+    var children []StackResource
+    var discoveryErrors []DiscoveryError
+    resourcesChecked := 0
+    stackName := chain[len(chain)-1]
 
-    // Build the request with its input parameters
-    resp, err := svc.ListStackResources(context.TODO(), &cloudformation.ListStackResourcesInput{
-        StackName: &stackName,
+    if visited[stackId] {
+        return children, resourcesChecked, discoveryErrors
+    }
+    visited[stackId] = true
+
+    // Page through every resource of the stack
+    paginator := cloudformation.NewListStackResourcesPaginator(svc, &cloudformation.ListStackResourcesInput{
+        StackName: &stackId,
     })
+    for paginator.HasMorePages() {
+        resp, err := paginator.NextPage(context.TODO())
+        if err != nil {
+            logger.Error(fmt.Sprintf("failed to get stack resources, %v", err))
+            discoveryErrors = append(discoveryErrors, DiscoveryError{
+                Region: region,
+                Stack:  strings.Join(chain, " > "),
+                Err:    fmt.Errorf("failed to list stack resources, %w", err),
+            })
+            break
+        }
+
+        for _, stackResourceSummary := range resp.StackResourceSummaries {
+            resourcesChecked++
+            if stackResourceSummary.PhysicalResourceId == nil {
+                logger.Warn(fmt.Sprintf("A child of stack %v has no physical resource ID, and so won't be added to the preservation list.", stackName))
+                fmt.Printf("A child of stack %v has no physical resource ID, and so won't be added to the preservation list.%v\n", stackName, *stackResourceSummary.LogicalResourceId)
+                continue
+            }
+
+            child := StackResource{
+                Region:       region,
+                Chain:        chain,
+                LogicalID:    *stackResourceSummary.LogicalResourceId,
+                ResourceType: *stackResourceSummary.ResourceType,
+                PhysicalID:   *stackResourceSummary.PhysicalResourceId,
+            }
+
+            if child.ResourceType == "AWS::CloudFormation::Stack" {
+                // The physical ID of a nested stack is its ARN. Preserve it by name, and preserve everything inside it
+                nestedStackId := child.PhysicalID
+                child.PhysicalID = StackNameFromID(nestedStackId)
+
+                nestedChain := append(append([]string{}, chain...), child.PhysicalID)
+                nestedChildren, nestedChecked, nestedErrors := getStackChildren(logger, svc, nestedStackId, nestedChain, region, visited)
+                children = append(children, nestedChildren...)
+                resourcesChecked += nestedChecked
+                discoveryErrors = append(discoveryErrors, nestedErrors...)
+            }
+
+            children = append(children, child)
+        }
+    }
+
+    return children, resourcesChecked, discoveryErrors
+}
+
+// Return the resources retained from deleted stacks in the region which the selector chooses by name. CloudFormation lists deleted stacks
+// for 90 days, so resources retained from stacks deleted before then are not found. A retained nested stack is returned along with all its children
+func GetRetainedResources(logger *zap.Logger, selector *StackSelector, region string) ([]StackResource, []DiscoveryError) {
+    svc, err := newCFNClient(region)
     if err != nil {
-        logger.Error(fmt.Sprintf("failed to get stack resources, %v", err))
+        return nil, []DiscoveryError{{Region: region, Err: err}}
     }
-    if len(resp.StackResourceSummaries) != 0 {
+
+    var retained []StackResource
+    var discoveryErrors []DiscoveryError
+    paginator := cloudformation.NewListStacksPaginator(svc, &cloudformation.ListStacksInput{
+        StackStatusFilter: []types.StackStatus{types.StackStatusDeleteComplete},
+    })
+    for paginator.HasMorePages() {
+        resp, err := paginator.NextPage(context.TODO())
+        if err != nil {
+            discoveryErrors = append(discoveryErrors, DiscoveryError{Region: region, Err: fmt.Errorf("failed to list deleted stacks, %w", err)})
+            break
+        }
+
+        // Every deleted stack of a name is inspected, as each may have retained different resources
+        for _, stackSummary := range resp.StackSummaries {
+            preserve, decision := selector.Select(stackDetails(stackSummary, types.Stack{}))
+            if !preserve {
+                continue
+            }
+            fmt.Printf("%s: %s (DELETE_COMPLETE) - %s, inspecting it for retained resources\n", region, *stackSummary.StackName, decision)
+
+            stackRetained, stackErrors := getRetainedResources(logger, svc, *stackSummary.StackId, []string{*stackSummary.StackName}, region)
+            retained = append(retained, stackRetained...)
+            discoveryErrors = append(discoveryErrors, stackErrors...)
+        }
+    }
+
+    return retained, discoveryErrors
+}
+
+// Return the resources which were skipped when the deleted stack was deleted
+func getRetainedResources(logger *zap.Logger, svc cloudformation.ListStackResourcesAPIClient, stackId string, chain []string, region string) ([]StackResource, []DiscoveryError) {
+    var retained []StackResource
+    var discoveryErrors []DiscoveryError
+    paginator := cloudformation.NewListStackResourcesPaginator(svc, &cloudformation.ListStackResourcesInput{
+        StackName: &stackId,
+    })
+    for paginator.HasMorePages() {
+        resp, err := paginator.NextPage(context.TODO())
+        if err != nil {
+            discoveryErrors = append(discoveryErrors, DiscoveryError{
+                Region: region,
+                Stack:  strings.Join(chain, " > ") + " (deleted)",
+                Err:    fmt.Errorf("failed to list stack resources, %w", err),
+            })
+            break
+        }
+
         for _, stackResourceSummary := range resp.StackResourceSummaries {
-            if stackResourceSummary.PhysicalResourceId != nil {
-                resourcesByType[*stackResourceSummary.ResourceType] = append(resourcesByType[*stackResourceSummary.ResourceType], *stackResourceSummary.PhysicalResourceId)
-            } else {
-                logger.Warn(fmt.Sprintf("A child of stack %v has no physical resource ID, and so won't be added to the preservation list.", &stackName))
-                fmt.Printf("A child of stack %v has no physical resource ID, and so won't be added to the preservation list.%v\n", &stackName, stackResourceSummary)
+            if stackResourceSummary.ResourceStatus != types.ResourceStatusDeleteSkipped || stackResourceSummary.PhysicalResourceId == nil {
+                continue
             }
+
+            child := StackResource{
+                Region:       region,
+                Chain:        chain,
+                LogicalID:    *stackResourceSummary.LogicalResourceId,
+                ResourceType: *stackResourceSummary.ResourceType,
+                PhysicalID:   *stackResourceSummary.PhysicalResourceId,
+            }
+
+            if child.ResourceType == "AWS::CloudFormation::Stack" {
+                // A retained nested stack lives on with all of its children
+                nestedStackId := child.PhysicalID
+                child.PhysicalID = StackNameFromID(nestedStackId)
+                nestedChain := append(append([]string{}, chain...), child.PhysicalID)
+                nestedChildren, _, nestedErrors := getStackChildren(logger, svc, nestedStackId, nestedChain, region, map[string]bool{})
+                retained = append(retained, nestedChildren...)
+                discoveryErrors = append(discoveryErrors, nestedErrors...)
+            }
+            retained = append(retained, child)
         }
     }
 
-    return resourcesByType
-}
\ No newline at end of file
+    return retained, discoveryErrors
+}
+
+// Return the stack name from a stack ID of the form arn:aws:cloudformation:<region>:<account>:stack/<name>/<uuid>. Anything else is assumed to already be a name
+func StackNameFromID(stackId string) string {
+    parts := strings.Split(stackId, "/")
+    if strings.HasPrefix(stackId, "arn:") && len(parts) == 3 {
+        return parts[1]
+    }
+    return stackId
+}
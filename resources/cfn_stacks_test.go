@@ -0,0 +1,288 @@
+package resources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"go.uber.org/zap"
+)
+
+// Serves ListStackResources from pages of resources by stack ID, failing for the stacks in failing
+type fakeStackResources struct {
+    pages   map[string][][]types.StackResourceSummary
+    failing map[string]bool
+}
+
+func (f *fakeStackResources) ListStackResources(ctx context.Context, input *cloudformation.ListStackResourcesInput, optFns ...func(*cloudformation.Options)) (*cloudformation.ListStackResourcesOutput, error) {
+    stackId := aws.ToString(input.StackName)
+    if f.failing[stackId] {
+        return nil, errors.New("access denied")
+    }
+    page := 0
+    if input.NextToken != nil {
+        page, _ = strconv.Atoi(*input.NextToken)
+    }
+    pages := f.pages[stackId]
+    if len(pages) == 0 {
+        return nil, fmt.Errorf("stack %s does not exist", stackId)
+    }
+    output := &cloudformation.ListStackResourcesOutput{StackResourceSummaries: pages[page]}
+    if page+1 < len(pages) {
+        output.NextToken = aws.String(strconv.Itoa(page + 1))
+    }
+    return output, nil
+}
+
+func stackARN(name string) string {
+    return "arn:aws:cloudformation:eu-west-1:123456789012:stack/" + name + "/0a1b2c3d"
+}
+
+func resourceSummary(logicalId string, resourceType string, physicalId string, status types.ResourceStatus) types.StackResourceSummary {
+    return types.StackResourceSummary{LogicalResourceId: aws.String(logicalId), ResourceType: aws.String(resourceType), PhysicalResourceId: aws.String(physicalId), ResourceStatus: status}
+}
+
+// Describe each resource as <chain> <logical ID>=<physical ID>
+func describeStackResources(stackResources []StackResource) []string {
+    var described []string
+    for _, stackResource := range stackResources {
+        described = append(described, fmt.Sprintf("%s %s=%s", strings.Join(stackResource.Chain, " > "), stackResource.LogicalID, stackResource.PhysicalID))
+    }
+    return described
+}
+
+func describeDiscoveryErrors(discoveryErrors []DiscoveryError) []string {
+    var described []string
+    for _, discoveryError := range discoveryErrors {
+        described = append(described, discoveryError.Stack)
+    }
+    return described
+}
+
+func TestGetStackChildren(t *testing.T) {
+    const created = types.ResourceStatusCreateComplete
+    bucket := resourceSummary("Bucket", "AWS::S3::Bucket", "app-bucket", created)
+    queue := resourceSummary("Queue", "AWS::SQS::Queue", "https://sqs.eu-west-1.amazonaws.com/123456789012/app-queue", created)
+    database := resourceSummary("Database", "AWS::CloudFormation::Stack", stackARN("app-database"), created)
+    table := resourceSummary("Table", "AWS::DynamoDB::Table", "app-table", created)
+    backup := resourceSummary("Backup", "AWS::CloudFormation::Stack", stackARN("app-backup"), created)
+    vault := resourceSummary("Vault", "AWS::Backup::BackupVault", "app-vault", created)
+    pending := types.StackResourceSummary{LogicalResourceId: aws.String("Pending"), ResourceType: aws.String("AWS::SNS::Topic"), ResourceStatus: types.ResourceStatusCreateInProgress}
+
+    tests := []struct {
+        name     string
+        pages    map[string][][]types.StackResourceSummary
+        failing  []string
+        children []string
+        checked  int
+        errors   []string
+    }{
+        {
+            name:     "flat stack",
+            pages:    map[string][][]types.StackResourceSummary{stackARN("app"): {{bucket, queue}}},
+            children: []string{"app Bucket=app-bucket", "app Queue=https://sqs.eu-west-1.amazonaws.com/123456789012/app-queue"},
+            checked:  2,
+        },
+        {
+            name:     "every page",
+            pages:    map[string][][]types.StackResourceSummary{stackARN("app"): {{bucket}, {queue}}},
+            children: []string{"app Bucket=app-bucket", "app Queue=https://sqs.eu-west-1.amazonaws.com/123456789012/app-queue"},
+            checked:  2,
+        },
+        {
+            name:     "no physical ID yet",
+            pages:    map[string][][]types.StackResourceSummary{stackARN("app"): {{pending, bucket}}},
+            children: []string{"app Bucket=app-bucket"},
+            checked:  2,
+        },
+        {
+            name:     "nested stack",
+            pages:    map[string][][]types.StackResourceSummary{
+                stackARN("app"):          {{bucket, database}},
+                stackARN("app-database"): {{table}},
+            },
+            children: []string{"app Bucket=app-bucket", "app > app-database Table=app-table", "app Database=app-database"},
+            checked:  3,
+        },
+        {
+            name:     "nested to any depth",
+            pages:    map[string][][]types.StackResourceSummary{
+                stackARN("app"):          {{database}},
+                stackARN("app-database"): {{table, backup}},
+                stackARN("app-backup"):   {{vault}},
+            },
+            children: []string{
+                "app > app-database Table=app-table",
+                "app > app-database > app-backup Vault=app-vault",
+                "app > app-database Backup=app-backup",
+                "app Database=app-database",
+            },
+            checked:  4,
+        },
+        {
+            name:     "a stack nested twice is listed once",
+            pages:    map[string][][]types.StackResourceSummary{
+                stackARN("app"):          {{database, resourceSummary("Again", "AWS::CloudFormation::Stack", stackARN("app-database"), created)}},
+                stackARN("app-database"): {{table}},
+            },
+            children: []string{"app > app-database Table=app-table", "app Database=app-database", "app Again=app-database"},
+            checked:  3,
+        },
+        {
+            name:     "a nested stack which cannot be listed",
+            pages:    map[string][][]types.StackResourceSummary{
+                stackARN("app"):          {{bucket, database}},
+                stackARN("app-database"): {{table}},
+            },
+            failing:  []string{stackARN("app-database")},
+            children: []string{"app Bucket=app-bucket", "app Database=app-database"},
+            checked:  2,
+            errors:   []string{"app > app-database"},
+        },
+        {
+            name:    "a stack which cannot be listed",
+            pages:   map[string][][]types.StackResourceSummary{stackARN("app"): {{bucket}}},
+            failing: []string{stackARN("app")},
+            errors:  []string{"app"},
+        },
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            svc := &fakeStackResources{pages: test.pages, failing: make(map[string]bool)}
+            for _, stackId := range test.failing {
+                svc.failing[stackId] = true
+            }
+            children, checked, discoveryErrors := getStackChildren(zap.NewNop(), svc, stackARN("app"), []string{"app"}, "eu-west-1", map[string]bool{})
+            if described := describeStackResources(children); !reflect.DeepEqual(described, test.children) {
+                t.Errorf("children = %v, want %v", described, test.children)
+            }
+            if checked != test.checked {
+                t.Errorf("checked %d resources, want %d", checked, test.checked)
+            }
+            if described := describeDiscoveryErrors(discoveryErrors); !reflect.DeepEqual(described, test.errors) {
+                t.Errorf("errors for stacks %v, want %v", described, test.errors)
+            }
+            for _, child := range children {
+                if child.Region != "eu-west-1" {
+                    t.Errorf("%s is in region %q, want eu-west-1", child.LogicalID, child.Region)
+                }
+            }
+        })
+    }
+}
+
+func TestStackNameFromID(t *testing.T) {
+    tests := []struct {
+        stackId string
+        name    string
+    }{
+        {stackId: stackARN("app-database"), name: "app-database"},
+        {stackId: "arn:aws-cn:cloudformation:cn-north-1:123456789012:stack/app/0a1b2c3d", name: "app"},
+        {stackId: "app-database", name: "app-database"},
+        {stackId: "arn:aws:cloudformation:eu-west-1:123456789012:stackset/baseline:0a1b2c3d", name: "arn:aws:cloudformation:eu-west-1:123456789012:stackset/baseline:0a1b2c3d"},
+    }
+
+    for _, test := range tests {
+        if name := StackNameFromID(test.stackId); name != test.name {
+            t.Errorf("StackNameFromID(%q) = %q, want %q", test.stackId, name, test.name)
+        }
+    }
+}
+
+func TestGetRetainedResources(t *testing.T) {
+    const skipped = types.ResourceStatusDeleteSkipped
+    const deleted = types.ResourceStatusDeleteComplete
+    tests := []struct {
+        name     string
+        pages    map[string][][]types.StackResourceSummary
+        failing  []string
+        retained []string
+        errors   []string
+    }{
+        {
+            name:     "only skipped resources",
+            pages:    map[string][][]types.StackResourceSummary{stackARN("app"): {{
+                resourceSummary("Bucket", "AWS::S3::Bucket", "app-bucket", skipped),
+                resourceSummary("Queue", "AWS::SQS::Queue", "app-queue", deleted),
+                resourceSummary("Table", "AWS::DynamoDB::Table", "app-table", types.ResourceStatusDeleteFailed),
+            }}},
+            retained: []string{"app Bucket=app-bucket"},
+        },
+        {
+            name:     "every page",
+            pages:    map[string][][]types.StackResourceSummary{stackARN("app"): {
+                {resourceSummary("Bucket", "AWS::S3::Bucket", "app-bucket", skipped)},
+                {resourceSummary("Table", "AWS::DynamoDB::Table", "app-table", skipped)},
+            }},
+            retained: []string{"app Bucket=app-bucket", "app Table=app-table"},
+        },
+        {
+            name:     "skipped without a physical ID",
+            pages:    map[string][][]types.StackResourceSummary{stackARN("app"): {{
+                {LogicalResourceId: aws.String("Bucket"), ResourceType: aws.String("AWS::S3::Bucket"), ResourceStatus: skipped},
+            }}},
+        },
+        {
+            name:     "nothing retained",
+            pages:    map[string][][]types.StackResourceSummary{stackARN("app"): {{resourceSummary("Bucket", "AWS::S3::Bucket", "app-bucket", deleted)}}},
+        },
+        {
+            name:     "a retained nested stack keeps all its children",
+            pages:    map[string][][]types.StackResourceSummary{
+                stackARN("app"):          {{resourceSummary("Database", "AWS::CloudFormation::Stack", stackARN("app-database"), skipped)}},
+                stackARN("app-database"): {{
+                    resourceSummary("Table", "AWS::DynamoDB::Table", "app-table", types.ResourceStatusCreateComplete),
+                    resourceSummary("Alarm", "AWS::CloudWatch::Alarm", "app-alarm", types.ResourceStatusUpdateComplete),
+                }},
+            },
+            retained: []string{"app > app-database Table=app-table", "app > app-database Alarm=app-alarm", "app Database=app-database"},
+        },
+        {
+            name:     "a deleted nested stack is not inspected",
+            pages:    map[string][][]types.StackResourceSummary{
+                stackARN("app"):          {{resourceSummary("Database", "AWS::CloudFormation::Stack", stackARN("app-database"), deleted)}},
+                stackARN("app-database"): {{resourceSummary("Table", "AWS::DynamoDB::Table", "app-table", skipped)}},
+            },
+        },
+        {
+            name:     "a retained nested stack which cannot be listed",
+            pages:    map[string][][]types.StackResourceSummary{
+                stackARN("app"):          {{resourceSummary("Database", "AWS::CloudFormation::Stack", stackARN("app-database"), skipped)}},
+                stackARN("app-database"): {{resourceSummary("Table", "AWS::DynamoDB::Table", "app-table", types.ResourceStatusCreateComplete)}},
+            },
+            failing:  []string{stackARN("app-database")},
+            retained: []string{"app Database=app-database"},
+            errors:   []string{"app > app-database"},
+        },
+        {
+            name:    "a deleted stack which cannot be listed",
+            pages:   map[string][][]types.StackResourceSummary{stackARN("app"): {{resourceSummary("Bucket", "AWS::S3::Bucket", "app-bucket", skipped)}}},
+            failing: []string{stackARN("app")},
+            errors:  []string{"app (deleted)"},
+        },
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            svc := &fakeStackResources{pages: test.pages, failing: make(map[string]bool)}
+            for _, stackId := range test.failing {
+                svc.failing[stackId] = true
+            }
+            retained, discoveryErrors := getRetainedResources(zap.NewNop(), svc, stackARN("app"), []string{"app"}, "eu-west-1")
+            if described := describeStackResources(retained); !reflect.DeepEqual(described, test.retained) {
+                t.Errorf("retained = %v, want %v", described, test.retained)
+            }
+            if described := describeDiscoveryErrors(discoveryErrors); !reflect.DeepEqual(described, test.errors) {
+                t.Errorf("errors for stacks %v, want %v", described, test.errors)
+            }
+        })
+    }
+}
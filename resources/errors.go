@@ -0,0 +1,22 @@
+package resources
+
+import "fmt"
+
+// A part of the account which could not be inspected. Nothing in it is known to Shield, so nothing in it is protected
+type DiscoveryError struct {
+    Region string
+    // The stack which could not be inspected. Empty when the failure affects the whole region
+    Stack  string
+    Err    error
+}
+
+func (e DiscoveryError) Error() string {
+    if e.Stack == "" {
+        return fmt.Sprintf("region %s: %v", e.Region, e.Err)
+    }
+    return fmt.Sprintf("region %s, stack %s: %v", e.Region, e.Stack, e.Err)
+}
+
+func (e DiscoveryError) Unwrap() error {
+    return e.Err
+}
@@ -0,0 +1,180 @@
+package resources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/smithy-go"
+	"go.uber.org/zap"
+)
+
+// A stack pulled into the preservation set because a preserved stack imports one of its exports
+type StackDependency struct {
+    // The stack to preserve. Where the export comes from a nested stack, this is its root stack
+    Stack      Stack
+    // The stack which exports the value, which is Stack itself unless it is nested
+    Exporter   string
+    ExportName string
+    ImportedBy string
+}
+
+// An export of the region, with the stacks importing it
+type stackExport struct {
+    name      string
+    exporter  stackPlacement
+    importers []stackPlacement
+}
+
+// Where a stack sits: its own ID and name, and the ID, name and status of its root stack, which are its own unless it is nested
+type stackPlacement struct {
+    ID         string
+    Name       string
+    RootID     string
+    RootName   string
+    RootStatus string
+}
+
+// Return the stacks in the region which the preserved stacks depend on through Fn::ImportValue, following imports of imports.
+// A stack is treated as preserved when its ID, or the ID of its root stack, is in preservedStackIds
+func GetExportProducers(logger *zap.Logger, preservedStackIds []string, region string) ([]StackDependency, []DiscoveryError) {
+    svc, err := newCFNClient(region)
+    if err != nil {
+        return nil, []DiscoveryError{{Region: region, Err: err}}
+    }
+
+    placements := make(map[string]stackPlacement)
+    place := func(stack string) (stackPlacement, error) {
+        if placement, ok := placements[stack]; ok {
+            return placement, nil
+        }
+        placement, err := describePlacement(svc, stack)
+        if err != nil {
+            return placement, err
+        }
+        placements[stack] = placement
+        return placement, nil
+    }
+
+    // Every export of the region, with the stacks importing it
+    var exports []stackExport
+    paginator := cloudformation.NewListExportsPaginator(svc, &cloudformation.ListExportsInput{})
+    for paginator.HasMorePages() {
+        resp, err := paginator.NextPage(context.TODO())
+        if err != nil {
+            return nil, []DiscoveryError{{Region: region, Err: fmt.Errorf("failed to list exports, %w", err)}}
+        }
+        for _, exp := range resp.Exports {
+            importers, err := listImporters(svc, *exp.Name)
+            if err != nil {
+                return nil, []DiscoveryError{{Region: region, Err: fmt.Errorf("failed to list the imports of %s, %w", *exp.Name, err)}}
+            }
+            if len(importers) == 0 {
+                continue
+            }
+
+            exporter, err := place(*exp.ExportingStackId)
+            if err != nil {
+                return nil, []DiscoveryError{{Region: region, Err: err}}
+            }
+            current := stackExport{name: *exp.Name, exporter: exporter}
+            for _, importer := range importers {
+                placement, err := place(importer)
+                if err != nil {
+                    return nil, []DiscoveryError{{Region: region, Err: err}}
+                }
+                current.importers = append(current.importers, placement)
+            }
+            exports = append(exports, current)
+        }
+    }
+
+    return exportProducers(logger, exports, preservedStackIds), nil
+}
+
+// Return the stacks exporting values which the preserved stacks import, following imports of imports
+func exportProducers(logger *zap.Logger, exports []stackExport, preservedStackIds []string) []StackDependency {
+    preserved := make(map[string]bool)
+    for _, stackId := range preservedStackIds {
+        preserved[stackId] = true
+    }
+    isPreserved := func(placement stackPlacement) bool {
+        return preserved[placement.ID] || preserved[placement.RootID]
+    }
+
+    // Pull in the exporters of values imported by preserved stacks until nothing changes, as pulled in stacks may import values themselves
+    var dependencies []StackDependency
+    for changed := true; changed; {
+        changed = false
+        for _, exp := range exports {
+            if isPreserved(exp.exporter) {
+                continue
+            }
+            for _, importer := range exp.importers {
+                if !isPreserved(importer) {
+                    continue
+                }
+                logger.Debug(fmt.Sprintf("%s imports %s from %s", importer.Name, exp.name, exp.exporter.Name))
+                dependencies = append(dependencies, StackDependency{
+                    Stack:      Stack{
+                        Name:   exp.exporter.RootName,
+                        ID:     exp.exporter.RootID,
+                        Status: exp.exporter.RootStatus,
+                        Reason: fmt.Sprintf("PRESERVE, exports %s which is imported by preserved stack %s", exp.name, importer.Name),
+                    },
+                    Exporter:   exp.exporter.Name,
+                    ExportName: exp.name,
+                    ImportedBy: importer.Name,
+                })
+                preserved[exp.exporter.RootID] = true
+                changed = true
+                break
+            }
+        }
+    }
+
+    return dependencies
+}
+
+// Return the names of the stacks importing the export. CloudFormation reports an export which nothing imports as an error
+func listImporters(svc *cloudformation.Client, exportName string) ([]string, error) {
+    var importers []string
+    paginator := cloudformation.NewListImportsPaginator(svc, &cloudformation.ListImportsInput{ExportName: &exportName})
+    for paginator.HasMorePages() {
+        resp, err := paginator.NextPage(context.TODO())
+        var apiErr smithy.APIError
+        if errors.As(err, &apiErr) && strings.Contains(apiErr.ErrorMessage(), "is not imported by any stack") {
+            return nil, nil
+        }
+        if err != nil {
+            return nil, err
+        }
+        importers = append(importers, resp.Imports...)
+    }
+    return importers, nil
+}
+
+// Describe the stack with the given name or ID, to find its root stack
+func describePlacement(svc *cloudformation.Client, stack string) (stackPlacement, error) {
+    resp, err := svc.DescribeStacks(context.TODO(), &cloudformation.DescribeStacksInput{StackName: &stack})
+    if err != nil {
+        return stackPlacement{}, fmt.Errorf("failed to describe stack %s, %w", stack, err)
+    }
+    if len(resp.Stacks) == 0 {
+        return stackPlacement{}, fmt.Errorf("stack %s not found", stack)
+    }
+
+    described := resp.Stacks[0]
+    placement := stackPlacement{ID: *described.StackId, Name: *described.StackName}
+    placement.RootID, placement.RootName, placement.RootStatus = placement.ID, placement.Name, string(described.StackStatus)
+    if described.RootId != nil {
+        root, err := describePlacement(svc, *described.RootId)
+        if err != nil {
+            return stackPlacement{}, err
+        }
+        placement.RootID, placement.RootName, placement.RootStatus = root.ID, root.Name, root.RootStatus
+    }
+    return placement, nil
+}
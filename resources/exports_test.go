@@ -0,0 +1,130 @@
+package resources
+
+import (
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestExportProducers(t *testing.T) {
+    stack := func(name string) stackPlacement {
+        return stackPlacement{ID: "id-" + name, Name: name, RootID: "id-" + name, RootName: name, RootStatus: "CREATE_COMPLETE"}
+    }
+    nested := func(name string, root string) stackPlacement {
+        return stackPlacement{ID: "id-" + name, Name: name, RootID: "id-" + root, RootName: root, RootStatus: "UPDATE_COMPLETE"}
+    }
+    // The exporter of each dependency, as <root stack> <- <export> <- <importer>
+    describe := func(dependencies []StackDependency) []string {
+        var described []string
+        for _, dependency := range dependencies {
+            described = append(described, dependency.Stack.Name+" <- "+dependency.ExportName+" <- "+dependency.ImportedBy)
+        }
+        return described
+    }
+
+    tests := []struct {
+        name      string
+        exports   []stackExport
+        preserved []string
+        want      []string
+    }{
+        {
+            name:      "nothing preserved",
+            exports:   []stackExport{{name: "vpc-id", exporter: stack("network"), importers: []stackPlacement{stack("app")}}},
+            preserved: nil,
+        },
+        {
+            name:      "importer preserved",
+            exports:   []stackExport{{name: "vpc-id", exporter: stack("network"), importers: []stackPlacement{stack("app")}}},
+            preserved: []string{"id-app"},
+            want:      []string{"network <- vpc-id <- app"},
+        },
+        {
+            name:      "only the exporter preserved",
+            exports:   []stackExport{{name: "vpc-id", exporter: stack("network"), importers: []stackPlacement{stack("app")}}},
+            preserved: []string{"id-network"},
+        },
+        {
+            name:      "exporter already preserved",
+            exports:   []stackExport{{name: "vpc-id", exporter: stack("network"), importers: []stackPlacement{stack("app")}}},
+            preserved: []string{"id-app", "id-network"},
+        },
+        {
+            name:      "an unpreserved importer",
+            exports:   []stackExport{{name: "vpc-id", exporter: stack("network"), importers: []stackPlacement{stack("sandbox"), stack("app")}}},
+            preserved: []string{"id-app"},
+            want:      []string{"network <- vpc-id <- app"},
+        },
+        {
+            name:      "several exports of one stack are pulled in once",
+            exports:   []stackExport{
+                {name: "vpc-id", exporter: stack("network"), importers: []stackPlacement{stack("app")}},
+                {name: "subnet-ids", exporter: stack("network"), importers: []stackPlacement{stack("app")}},
+            },
+            preserved: []string{"id-app"},
+            want:      []string{"network <- vpc-id <- app"},
+        },
+        {
+            name:      "imports of imports",
+            exports:   []stackExport{
+                {name: "key-arn", exporter: stack("kms"), importers: []stackPlacement{stack("network")}},
+                {name: "vpc-id", exporter: stack("network"), importers: []stackPlacement{stack("app")}},
+            },
+            preserved: []string{"id-app"},
+            want:      []string{"network <- vpc-id <- app", "kms <- key-arn <- network"},
+        },
+        {
+            name:      "an importer nested in a preserved stack",
+            exports:   []stackExport{{name: "vpc-id", exporter: stack("network"), importers: []stackPlacement{nested("app-database", "app")}}},
+            preserved: []string{"id-app"},
+            want:      []string{"network <- vpc-id <- app-database"},
+        },
+        {
+            name:      "an exporter nested in another stack pulls in its root",
+            exports:   []stackExport{{name: "vpc-id", exporter: nested("network-vpc", "network"), importers: []stackPlacement{stack("app")}}},
+            preserved: []string{"id-app"},
+            want:      []string{"network <- vpc-id <- app"},
+        },
+        {
+            name:      "an exporter nested in a preserved stack",
+            exports:   []stackExport{{name: "vpc-id", exporter: nested("app-network", "app"), importers: []stackPlacement{stack("app")}}},
+            preserved: []string{"id-app"},
+        },
+        {
+            name:      "a cycle ends",
+            exports:   []stackExport{
+                {name: "a-out", exporter: stack("a"), importers: []stackPlacement{stack("b")}},
+                {name: "b-out", exporter: stack("b"), importers: []stackPlacement{stack("a")}},
+            },
+            preserved: []string{"id-a"},
+            want:      []string{"b <- b-out <- a"},
+        },
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            dependencies := exportProducers(zap.NewNop(), test.exports, test.preserved)
+            if described := describe(dependencies); !reflect.DeepEqual(described, test.want) {
+                t.Errorf("exportProducers() = %v, want %v", described, test.want)
+            }
+        })
+    }
+}
+
+func TestExportProducersPreserveTheRootStack(t *testing.T) {
+    exports := []stackExport{{
+        name:      "vpc-id",
+        exporter:  stackPlacement{ID: "id-network-vpc", Name: "network-vpc", RootID: "id-network", RootName: "network", RootStatus: "UPDATE_COMPLETE"},
+        importers: []stackPlacement{{ID: "id-app", Name: "app", RootID: "id-app", RootName: "app"}},
+    }}
+    want := []StackDependency{{
+        Stack:      Stack{Name: "network", ID: "id-network", Status: "UPDATE_COMPLETE", Reason: "PRESERVE, exports vpc-id which is imported by preserved stack app"},
+        Exporter:   "network-vpc",
+        ExportName: "vpc-id",
+        ImportedBy: "app",
+    }}
+    if dependencies := exportProducers(zap.NewNop(), exports, []string{"id-app"}); !reflect.DeepEqual(dependencies, want) {
+        t.Errorf("exportProducers() = %+v, want %+v", dependencies, want)
+    }
+}
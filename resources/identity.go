@@ -0,0 +1,161 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"go.uber.org/zap"
+)
+
+// The IAM principals which credentials can belong to
+const (
+    PrincipalRole = "role"
+    PrincipalUser = "user"
+)
+
+// Who the current credentials belong to
+type CallerIdentity struct {
+    Account       string
+    ARN           string
+    // PrincipalRole or PrincipalUser, or empty for principals which are not IAM roles or users, such as the root user
+    PrincipalType string
+    // The name of the role or user
+    PrincipalName string
+}
+
+// Return the identity which the current credentials belong to. Its account is the account which aws-nuke will run against
+func GetCallerIdentity(logger *zap.Logger, region string) (CallerIdentity, error) {
+    cfg, err := config.LoadDefaultConfig(context.TODO(),
+        config.WithRegion(region),
+    )
+    if err != nil {
+        return CallerIdentity{}, fmt.Errorf("unable to load SDK config, %w", err)
+    }
+
+    svc := sts.NewFromConfig(cfg)
+    resp, err := svc.GetCallerIdentity(context.TODO(), &sts.GetCallerIdentityInput{})
+    if err != nil {
+        return CallerIdentity{}, fmt.Errorf("failed to get caller identity, %w", err)
+    }
+    logger.Debug(fmt.Sprintf("Caller identity: %v", *resp.Arn))
+
+    identity := CallerIdentity{Account: *resp.Account, ARN: *resp.Arn}
+    identity.PrincipalType, identity.PrincipalName = principalFromARN(*resp.Arn)
+    return identity, nil
+}
+
+// Return the role or user behind a caller ARN. Assumed roles, including the roles of SSO permission sets, are
+// arn:aws:sts::<account>:assumed-role/<role>/<session>, and users arn:aws:iam::<account>:user/<path>/<name>
+func principalFromARN(arn string) (string, string) {
+    parsed, err := ParseARN(arn)
+    if err != nil {
+        return "", ""
+    }
+    switch {
+    case parsed.Service == "sts" && parsed.ResourceType == "assumed-role":
+        return PrincipalRole, strings.Split(parsed.Resource, "/")[0]
+    case parsed.Service == "iam" && parsed.ResourceType == "user":
+        segments := strings.Split(parsed.Resource, "/")
+        return PrincipalUser, segments[len(segments)-1]
+    }
+    return "", ""
+}
+
+// A resource which the caller needs in order to keep running, identified as CFN would identify it
+type CallerResource struct {
+    CFNType    string
+    PhysicalID string
+    // Why the resource is needed
+    Reason     string
+}
+
+// Return the caller's role or user, along with the managed policies attached to it, its instance profiles (for roles) and its groups
+// and their managed policies (for users). Inline policies and attachments are preserved by the companion rules of the role or user
+func GetCallerResources(logger *zap.Logger, identity CallerIdentity, region string) ([]CallerResource, error) {
+    if identity.PrincipalType == "" {
+        return nil, nil
+    }
+
+    cfg, err := config.LoadDefaultConfig(context.TODO(),
+        config.WithRegion(region),
+    )
+    if err != nil {
+        return nil, fmt.Errorf("unable to load SDK config, %w", err)
+    }
+    svc := iam.NewFromConfig(cfg)
+    name := identity.PrincipalName
+
+    if identity.PrincipalType == PrincipalUser {
+        callerResources := []CallerResource{{CFNType: "AWS::IAM::User", PhysicalID: name, Reason: "the user running Shield"}}
+
+        policyPaginator := iam.NewListAttachedUserPoliciesPaginator(svc, &iam.ListAttachedUserPoliciesInput{UserName: &name})
+        for policyPaginator.HasMorePages() {
+            resp, err := policyPaginator.NextPage(context.TODO())
+            if err != nil {
+                return nil, fmt.Errorf("failed to list the policies attached to user %s, %w", name, err)
+            }
+            callerResources = append(callerResources, customerManagedPolicies(resp.AttachedPolicies, fmt.Sprintf("attached to user %s", name))...)
+        }
+
+        groupPaginator := iam.NewListGroupsForUserPaginator(svc, &iam.ListGroupsForUserInput{UserName: &name})
+        for groupPaginator.HasMorePages() {
+            resp, err := groupPaginator.NextPage(context.TODO())
+            if err != nil {
+                return nil, fmt.Errorf("failed to list the groups of user %s, %w", name, err)
+            }
+            for _, group := range resp.Groups {
+                callerResources = append(callerResources, CallerResource{CFNType: "AWS::IAM::Group", PhysicalID: *group.GroupName, Reason: fmt.Sprintf("user %s is a member", name)})
+
+                groupPolicyPaginator := iam.NewListAttachedGroupPoliciesPaginator(svc, &iam.ListAttachedGroupPoliciesInput{GroupName: group.GroupName})
+                for groupPolicyPaginator.HasMorePages() {
+                    groupResp, err := groupPolicyPaginator.NextPage(context.TODO())
+                    if err != nil {
+                        return nil, fmt.Errorf("failed to list the policies attached to group %s, %w", *group.GroupName, err)
+                    }
+                    callerResources = append(callerResources, customerManagedPolicies(groupResp.AttachedPolicies, fmt.Sprintf("attached to group %s", *group.GroupName))...)
+                }
+            }
+        }
+        return callerResources, nil
+    }
+
+    callerResources := []CallerResource{{CFNType: "AWS::IAM::Role", PhysicalID: name, Reason: "the role running Shield"}}
+
+    policyPaginator := iam.NewListAttachedRolePoliciesPaginator(svc, &iam.ListAttachedRolePoliciesInput{RoleName: &name})
+    for policyPaginator.HasMorePages() {
+        resp, err := policyPaginator.NextPage(context.TODO())
+        if err != nil {
+            return nil, fmt.Errorf("failed to list the policies attached to role %s, %w", name, err)
+        }
+        callerResources = append(callerResources, customerManagedPolicies(resp.AttachedPolicies, fmt.Sprintf("attached to role %s", name))...)
+    }
+
+    profilePaginator := iam.NewListInstanceProfilesForRolePaginator(svc, &iam.ListInstanceProfilesForRoleInput{RoleName: &name})
+    for profilePaginator.HasMorePages() {
+        resp, err := profilePaginator.NextPage(context.TODO())
+        if err != nil {
+            return nil, fmt.Errorf("failed to list the instance profiles of role %s, %w", name, err)
+        }
+        for _, profile := range resp.InstanceProfiles {
+            callerResources = append(callerResources, CallerResource{CFNType: "AWS::IAM::InstanceProfile", PhysicalID: *profile.InstanceProfileName, Reason: fmt.Sprintf("holds role %s", name)})
+        }
+    }
+    return callerResources, nil
+}
+
+// Return the customer managed policies among the attached policies. AWS managed policies cannot be deleted, so need no protection
+func customerManagedPolicies(attachedPolicies []types.AttachedPolicy, reason string) []CallerResource {
+    var policies []CallerResource
+    for _, policy := range attachedPolicies {
+        if parsed, err := ParseARN(*policy.PolicyArn); err == nil && parsed.Account == "aws" {
+            continue
+        }
+        policies = append(policies, CallerResource{CFNType: "AWS::IAM::ManagedPolicy", PhysicalID: *policy.PolicyArn, Reason: reason})
+    }
+    return policies
+}
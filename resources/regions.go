@@ -0,0 +1,43 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/account"
+	"github.com/aws/aws-sdk-go-v2/service/account/types"
+	"go.uber.org/zap"
+)
+
+// The pseudo region used by aws-nuke for global resources such as IAM. It holds no CFN stacks, so is never scanned
+const GlobalRegion = "global"
+
+// Return the regions which are enabled in the account, including opt-in regions which have been enabled
+func GetEnabledRegions(logger *zap.Logger, region string) ([]string, error) {
+    var regions []string
+
+    cfg, err := config.LoadDefaultConfig(context.TODO(),
+        config.WithRegion(region),
+    )
+    if err != nil {
+        return nil, fmt.Errorf("unable to load SDK config, %w", err)
+    }
+
+    svc := account.NewFromConfig(cfg)
+    paginator := account.NewListRegionsPaginator(svc, &account.ListRegionsInput{
+        RegionOptStatusContains: []types.RegionOptStatus{types.RegionOptStatusEnabled, types.RegionOptStatusEnabledByDefault},
+    })
+    for paginator.HasMorePages() {
+        resp, err := paginator.NextPage(context.TODO())
+        if err != nil {
+            return nil, fmt.Errorf("failed to list the enabled regions, %w", err)
+        }
+        for _, enabledRegion := range resp.Regions {
+            regions = append(regions, *enabledRegion.RegionName)
+        }
+    }
+    logger.Debug(fmt.Sprintf("Enabled regions: %v", regions))
+
+    return regions, nil
+}
@@ -0,0 +1,256 @@
+package resources
+
+import (
+	"awsnukeshield/helpers"
+	"awsnukeshield/nukeconfig"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// What the user selects stacks by. A stack is preserved when any of the positive selectors (name regexes, stack tags, termination
+// protection, description regexes and role regexes) matches it, it is within the time window, and it matches none of the exclude regexes
+type StackCriteria struct {
+    Includes             []string
+    Excludes             []string
+    Tags                 []nukeconfig.Filter
+    TerminationProtected bool
+    DescriptionRegexes   []string
+    RoleRegexes          []string
+    // Names of StackSets whose instances in the account are preserved
+    StackSets            []string
+    // Also match the instances of the StackSets by their StackSet-<name>-<uuid> stack names, for StackSets administered from another account
+    StackSetNameFallback bool
+    // The time window. Zero times leave that side of the window open
+    CreatedAfter         time.Time
+    CreatedBefore        time.Time
+    UpdatedAfter         time.Time
+    UpdatedBefore        time.Time
+}
+
+// What is known of a stack when selecting it. The fields other than the name and times are only filled in from DescribeStacks
+// when the selector needs them
+type StackDetails struct {
+    Name                 string
+    ID                   string
+    Description          string
+    Tags                 map[string]string
+    TerminationProtected bool
+    RoleARN              string
+    CreationTime         time.Time
+    LastUpdatedTime      time.Time
+}
+
+// Decides which stacks are preserved, from the compiled criteria
+type StackSelector struct {
+    criteria     StackCriteria
+    includes     []*regexp.Regexp
+    excludes     []*regexp.Regexp
+    descriptions []*regexp.Regexp
+    roles        []*regexp.Regexp
+    // The stack name regexes of the StackSets, with StackSetNameFallback
+    stackSets    map[string]*regexp.Regexp
+    // StackSet names of the instance stacks found with the StackSet APIs, by stack ID
+    instances    map[string]string
+    // Names of the stacks selected by name as instances of each StackSet, rather than found with the StackSet APIs
+    byName       map[string][]string
+}
+
+// Compile the regexes of the criteria, returning an error for the first invalid one
+func NewStackSelector(criteria StackCriteria) (*StackSelector, error) {
+    selector := &StackSelector{criteria: criteria, stackSets: make(map[string]*regexp.Regexp), instances: make(map[string]string), byName: make(map[string][]string)}
+    var err error
+    if selector.includes, err = compileRegexes(criteria.Includes); err != nil {
+        return nil, err
+    }
+    if selector.excludes, err = compileRegexes(criteria.Excludes); err != nil {
+        return nil, err
+    }
+    if selector.descriptions, err = compileRegexes(criteria.DescriptionRegexes); err != nil {
+        return nil, err
+    }
+    if selector.roles, err = compileRegexes(criteria.RoleRegexes); err != nil {
+        return nil, err
+    }
+    for _, stackSetName := range criteria.StackSets {
+        if stackSetName != "" && criteria.StackSetNameFallback {
+            selector.stackSets[stackSetName] = stackSetStackNameRegex(stackSetName)
+        }
+    }
+    for _, filter := range criteria.Tags {
+        if _, err := filter.Match(""); err != nil {
+            return nil, fmt.Errorf("invalid stack tag selector %v: %w", filter, err)
+        }
+    }
+    if !criteria.CreatedAfter.IsZero() && !criteria.CreatedBefore.IsZero() && !criteria.CreatedAfter.Before(criteria.CreatedBefore) {
+        return nil, fmt.Errorf("the created after time must be before the created before time")
+    }
+    if !criteria.UpdatedAfter.IsZero() && !criteria.UpdatedBefore.IsZero() && !criteria.UpdatedAfter.Before(criteria.UpdatedBefore) {
+        return nil, fmt.Errorf("the updated after time must be before the updated before time")
+    }
+    return selector, nil
+}
+
+func compileRegexes(regexes []string) ([]*regexp.Regexp, error) {
+    var compiled []*regexp.Regexp
+    for _, regex := range regexes {
+        if regex == "" {
+            continue
+        }
+        re, err := regexp.Compile(regex)
+        if err != nil {
+            return nil, fmt.Errorf("invalid regex %q: %w", regex, err)
+        }
+        compiled = append(compiled, re)
+    }
+    return compiled, nil
+}
+
+// The names of the StackSets whose instances are selected
+func (s *StackSelector) StackSets() []string {
+    var stackSetNames []string
+    for _, stackSetName := range s.criteria.StackSets {
+        if stackSetName != "" {
+            stackSetNames = append(stackSetNames, stackSetName)
+        }
+    }
+    return stackSetNames
+}
+
+// Select the instance stacks of StackSets found with the StackSet APIs
+func (s *StackSelector) AddStackSetInstances(instances []StackSetInstance) {
+    for _, instance := range instances {
+        s.instances[instance.StackID] = instance.StackSetName
+    }
+}
+
+// The StackSets none of whose instances were found with the StackSet APIs
+func (s *StackSelector) StackSetsWithoutInstances() []string {
+    found := make(map[string]bool)
+    for _, stackSetName := range s.instances {
+        found[stackSetName] = true
+    }
+    var missing []string
+    for _, stackSetName := range s.StackSets() {
+        if !found[stackSetName] {
+            missing = append(missing, stackSetName)
+        }
+    }
+    return missing
+}
+
+// The names of the stacks selected by name as instances of each StackSet, with StackSetNameFallback, rather than found with the StackSet APIs
+func (s *StackSelector) StackSetInstancesByName() map[string][]string {
+    return s.byName
+}
+
+// Whether the selector needs the details of stacks from DescribeStacks, which ListStacks does not return
+func (s *StackSelector) NeedsDetails() bool {
+    return len(s.criteria.Tags) != 0 || s.criteria.TerminationProtected || len(s.descriptions) != 0 || len(s.roles) != 0
+}
+
+// Whether the stack is preserved, and the selectors which decided it
+func (s *StackSelector) Select(stack StackDetails) (bool, string) {
+    reason := s.positiveMatch(stack)
+    if reason == "" {
+        return false, "no selector matched"
+    }
+
+    if outside := s.outsideWindow(stack); outside != "" {
+        return false, fmt.Sprintf("skipped, %s but %s", reason, outside)
+    }
+    for _, re := range s.excludes {
+        if re.MatchString(stack.Name) {
+            return false, fmt.Sprintf("skipped, %s but excluded by %q", reason, re)
+        }
+    }
+    return true, fmt.Sprintf("PRESERVE, %s", reason)
+}
+
+// Describe the first positive selector matching the stack, or return an empty string if none does
+func (s *StackSelector) positiveMatch(stack StackDetails) string {
+    for _, re := range s.includes {
+        if re.MatchString(stack.Name) {
+            return fmt.Sprintf("matched include regex %q", re)
+        }
+    }
+    if stackSetName, ok := s.instances[stack.ID]; ok {
+        return fmt.Sprintf("instance of StackSet %q", stackSetName)
+    }
+    for _, stackSetName := range s.StackSets() {
+        if re, ok := s.stackSets[stackSetName]; ok && re.MatchString(stack.Name) {
+            if helpers.FindItemExact(s.byName[stackSetName], stack.Name) == -1 {
+                s.byName[stackSetName] = append(s.byName[stackSetName], stack.Name)
+            }
+            return fmt.Sprintf("named as an instance of StackSet %q", stackSetName)
+        }
+    }
+    for _, filter := range s.criteria.Tags {
+        key := strings.TrimPrefix(filter.Property, "tag:")
+        if value, tagged := stack.Tags[key]; tagged {
+            if matched, _ := filter.Match(value); matched {
+                return fmt.Sprintf("matched stack tag %s=%s", key, value)
+            }
+        }
+    }
+    if s.criteria.TerminationProtected && stack.TerminationProtected {
+        return "termination protection is enabled"
+    }
+    for _, re := range s.descriptions {
+        if re.MatchString(stack.Description) {
+            return fmt.Sprintf("matched description regex %q", re)
+        }
+    }
+    for _, re := range s.roles {
+        if stack.RoleARN != "" && re.MatchString(stack.RoleARN) {
+            return fmt.Sprintf("matched role regex %q", re)
+        }
+    }
+    return ""
+}
+
+// Describe why the stack is outside the time window, or return an empty string if it is within it
+func (s *StackSelector) outsideWindow(stack StackDetails) string {
+    // A stack which was never updated was last changed when it was created
+    updated := stack.LastUpdatedTime
+    if updated.IsZero() {
+        updated = stack.CreationTime
+    }
+
+    switch {
+    case !s.criteria.CreatedAfter.IsZero() && stack.CreationTime.Before(s.criteria.CreatedAfter):
+        return fmt.Sprintf("was created before %s", s.criteria.CreatedAfter.Format(time.RFC3339))
+    case !s.criteria.CreatedBefore.IsZero() && !stack.CreationTime.Before(s.criteria.CreatedBefore):
+        return fmt.Sprintf("was created after %s", s.criteria.CreatedBefore.Format(time.RFC3339))
+    case !s.criteria.UpdatedAfter.IsZero() && updated.Before(s.criteria.UpdatedAfter):
+        return fmt.Sprintf("was last updated before %s", s.criteria.UpdatedAfter.Format(time.RFC3339))
+    case !s.criteria.UpdatedBefore.IsZero() && !updated.Before(s.criteria.UpdatedBefore):
+        return fmt.Sprintf("was last updated after %s", s.criteria.UpdatedBefore.Format(time.RFC3339))
+    }
+    return ""
+}
+
+// Parse a point in time given on the command line: an RFC 3339 time, a date (2006-01-02), or an age such as 36h or 30d, meaning that long before now
+func ParseTime(value string, now time.Time) (time.Time, error) {
+    value = strings.TrimSpace(value)
+    if value == "" {
+        return time.Time{}, nil
+    }
+    if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+        return parsed, nil
+    }
+    if parsed, err := time.Parse(time.DateOnly, value); err == nil {
+        return parsed, nil
+    }
+    if days, found := strings.CutSuffix(value, "d"); found {
+        if count, err := strconv.Atoi(days); err == nil && count >= 0 {
+            return now.AddDate(0, 0, -count), nil
+        }
+    }
+    if age, err := time.ParseDuration(value); err == nil && age >= 0 {
+        return now.Add(-age), nil
+    }
+    return time.Time{}, fmt.Errorf("invalid time %q, expected an RFC 3339 time, a date (2006-01-02) or an age such as 36h or 30d", value)
+}
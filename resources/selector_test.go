@@ -0,0 +1,252 @@
+package resources
+
+import (
+	"awsnukeshield/nukeconfig"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStackSetNameFallback(t *testing.T) {
+    const instanceName = "StackSet-baseline-0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d"
+    tests := []struct {
+        fallback bool
+        selected []string
+        byName   map[string][]string
+    }{
+        {fallback: false, selected: []string{"api-instance"}, byName: map[string][]string{}},
+        {fallback: true, selected: []string{"api-instance", instanceName}, byName: map[string][]string{"baseline": {instanceName}}},
+    }
+
+    for _, test := range tests {
+        selector, err := NewStackSelector(StackCriteria{StackSets: []string{"baseline", "other"}, StackSetNameFallback: test.fallback})
+        if err != nil {
+            t.Fatal(err)
+        }
+        selector.AddStackSetInstances([]StackSetInstance{{StackSetName: "baseline", Region: "eu-west-1", StackID: "arn:aws:cloudformation:eu-west-1:123456789012:stack/api-instance/1"}})
+
+        var selected []string
+        for _, stack := range []StackDetails{
+            {Name: "api-instance", ID: "arn:aws:cloudformation:eu-west-1:123456789012:stack/api-instance/1"},
+            {Name: instanceName, ID: "arn:aws:cloudformation:eu-west-1:123456789012:stack/" + instanceName + "/2"},
+            {Name: "StackSet-baseline-extra", ID: "arn:aws:cloudformation:eu-west-1:123456789012:stack/StackSet-baseline-extra/3"},
+        } {
+            if preserve, _ := selector.Select(stack); preserve {
+                selected = append(selected, stack.Name)
+            }
+        }
+        if !reflect.DeepEqual(selected, test.selected) {
+            t.Errorf("fallback %v: selected %v, want %v", test.fallback, selected, test.selected)
+        }
+        if byName := selector.StackSetInstancesByName(); !reflect.DeepEqual(byName, test.byName) {
+            t.Errorf("fallback %v: resolved by name %v, want %v", test.fallback, byName, test.byName)
+        }
+        if missing := selector.StackSetsWithoutInstances(); !reflect.DeepEqual(missing, []string{"other"}) {
+            t.Errorf("fallback %v: StackSets without instances %v, want [other]", test.fallback, missing)
+        }
+    }
+}
+
+func TestSelect(t *testing.T) {
+    created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+    updated := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+    tests := []struct {
+        name     string
+        criteria StackCriteria
+        stack    StackDetails
+        preserve bool
+        reason   string
+    }{
+        {
+            name: "include regex", criteria: StackCriteria{Includes: []string{"^api-"}},
+            stack: StackDetails{Name: "api-prod"}, preserve: true, reason: `PRESERVE, matched include regex "^api-"`,
+        },
+        {
+            name: "no selector", criteria: StackCriteria{Includes: []string{"^api-"}},
+            stack: StackDetails{Name: "web-prod"}, reason: "no selector matched",
+        },
+        {
+            name: "empty regexes are ignored", criteria: StackCriteria{Includes: []string{""}},
+            stack: StackDetails{Name: "web-prod"}, reason: "no selector matched",
+        },
+        {
+            name: "exclude wins over include", criteria: StackCriteria{Includes: []string{"^api-"}, Excludes: []string{"-sandbox$"}},
+            stack: StackDetails{Name: "api-sandbox"}, reason: `skipped, matched include regex "^api-" but excluded by "-sandbox$"`,
+        },
+        {
+            name: "exclude alone selects nothing", criteria: StackCriteria{Excludes: []string{"-sandbox$"}},
+            stack: StackDetails{Name: "api-prod"}, reason: "no selector matched",
+        },
+        {
+            name: "first include is reported", criteria: StackCriteria{Includes: []string{"^api-", "-prod$"}},
+            stack: StackDetails{Name: "api-prod"}, preserve: true, reason: `PRESERVE, matched include regex "^api-"`,
+        },
+        {
+            name: "stack tag", criteria: StackCriteria{Tags: []nukeconfig.Filter{{Property: "tag:env", Value: "prod"}}},
+            stack: StackDetails{Name: "web", Tags: map[string]string{"env": "prod"}}, preserve: true, reason: "PRESERVE, matched stack tag env=prod",
+        },
+        {
+            name: "stack tag with another value", criteria: StackCriteria{Tags: []nukeconfig.Filter{{Property: "tag:env", Value: "prod"}}},
+            stack: StackDetails{Name: "web", Tags: map[string]string{"env": "dev"}}, reason: "no selector matched",
+        },
+        {
+            name: "termination protection", criteria: StackCriteria{TerminationProtected: true},
+            stack: StackDetails{Name: "web", TerminationProtected: true}, preserve: true, reason: "PRESERVE, termination protection is enabled",
+        },
+        {
+            name: "termination protection not asked for", criteria: StackCriteria{Includes: []string{"^api-"}},
+            stack: StackDetails{Name: "web", TerminationProtected: true}, reason: "no selector matched",
+        },
+        {
+            name: "description regex", criteria: StackCriteria{DescriptionRegexes: []string{"(?i)landing zone"}},
+            stack: StackDetails{Name: "web", Description: "Landing Zone baseline"}, preserve: true, reason: `PRESERVE, matched description regex "(?i)landing zone"`,
+        },
+        {
+            name: "role regex", criteria: StackCriteria{RoleRegexes: []string{":role/pipeline$"}},
+            stack: StackDetails{Name: "web", RoleARN: "arn:aws:iam::123456789012:role/pipeline"}, preserve: true, reason: `PRESERVE, matched role regex ":role/pipeline$"`,
+        },
+        {
+            name: "role regex without a role", criteria: StackCriteria{RoleRegexes: []string{".*"}},
+            stack: StackDetails{Name: "web"}, reason: "no selector matched",
+        },
+        {
+            name: "any positive selector is enough", criteria: StackCriteria{Includes: []string{"^api-"}, TerminationProtected: true},
+            stack: StackDetails{Name: "web", TerminationProtected: true}, preserve: true, reason: "PRESERVE, termination protection is enabled",
+        },
+        {
+            name: "exclude applies to every selector", criteria: StackCriteria{TerminationProtected: true, Excludes: []string{"^web$"}},
+            stack: StackDetails{Name: "web", TerminationProtected: true}, reason: `skipped, termination protection is enabled but excluded by "^web$"`,
+        },
+        {
+            name: "created after", criteria: StackCriteria{Includes: []string{"^api-"}, CreatedAfter: created.Add(-time.Hour)},
+            stack: StackDetails{Name: "api-prod", CreationTime: created}, preserve: true, reason: `PRESERVE, matched include regex "^api-"`,
+        },
+        {
+            name: "created before the window", criteria: StackCriteria{Includes: []string{"^api-"}, CreatedAfter: created.Add(time.Hour)},
+            stack: StackDetails{Name: "api-prod", CreationTime: created}, reason: `skipped, matched include regex "^api-" but was created before 2024-03-01T13:00:00Z`,
+        },
+        {
+            name: "created at the end of the window", criteria: StackCriteria{Includes: []string{"^api-"}, CreatedBefore: created},
+            stack: StackDetails{Name: "api-prod", CreationTime: created}, reason: `skipped, matched include regex "^api-" but was created after 2024-03-01T12:00:00Z`,
+        },
+        {
+            name: "updated within the window", criteria: StackCriteria{Includes: []string{"^api-"}, UpdatedAfter: updated.Add(-time.Hour), UpdatedBefore: updated.Add(time.Hour)},
+            stack: StackDetails{Name: "api-prod", CreationTime: created, LastUpdatedTime: updated}, preserve: true, reason: `PRESERVE, matched include regex "^api-"`,
+        },
+        {
+            name: "updated after the window", criteria: StackCriteria{Includes: []string{"^api-"}, UpdatedBefore: updated.Add(-time.Hour)},
+            stack: StackDetails{Name: "api-prod", CreationTime: created, LastUpdatedTime: updated}, reason: `skipped, matched include regex "^api-" but was last updated after 2024-06-01T11:00:00Z`,
+        },
+        {
+            name: "never updated counts as updated when created", criteria: StackCriteria{Includes: []string{"^api-"}, UpdatedAfter: updated},
+            stack: StackDetails{Name: "api-prod", CreationTime: created}, reason: `skipped, matched include regex "^api-" but was last updated before 2024-06-01T12:00:00Z`,
+        },
+        {
+            name: "the window is reported before the excludes", criteria: StackCriteria{Includes: []string{"^api-"}, Excludes: []string{"^api-"}, CreatedAfter: updated},
+            stack: StackDetails{Name: "api-prod", CreationTime: created}, reason: `skipped, matched include regex "^api-" but was created before 2024-06-01T12:00:00Z`,
+        },
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            selector, err := NewStackSelector(test.criteria)
+            if err != nil {
+                t.Fatal(err)
+            }
+            preserve, reason := selector.Select(test.stack)
+            if preserve != test.preserve || reason != test.reason {
+                t.Errorf("Select(%+v) = %v, %q, want %v, %q", test.stack, preserve, reason, test.preserve, test.reason)
+            }
+        })
+    }
+}
+
+func TestNewStackSelectorErrors(t *testing.T) {
+    created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+    tests := []struct {
+        name     string
+        criteria StackCriteria
+    }{
+        {name: "include regex", criteria: StackCriteria{Includes: []string{"("}}},
+        {name: "exclude regex", criteria: StackCriteria{Excludes: []string{"["}}},
+        {name: "description regex", criteria: StackCriteria{DescriptionRegexes: []string{"a{2,1}"}}},
+        {name: "role regex", criteria: StackCriteria{RoleRegexes: []string{"(?z)"}}},
+        {name: "stack tag regex", criteria: StackCriteria{Tags: []nukeconfig.Filter{{Property: "tag:env", Type: "regex", Value: "("}}}},
+        {name: "created window", criteria: StackCriteria{CreatedAfter: created, CreatedBefore: created}},
+        {name: "updated window", criteria: StackCriteria{UpdatedAfter: created.Add(time.Hour), UpdatedBefore: created}},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            if _, err := NewStackSelector(test.criteria); err == nil {
+                t.Errorf("NewStackSelector(%+v) succeeded, want an error", test.criteria)
+            }
+        })
+    }
+}
+
+func TestNeedsDetails(t *testing.T) {
+    tests := []struct {
+        name     string
+        criteria StackCriteria
+        needs    bool
+    }{
+        {name: "names only", criteria: StackCriteria{Includes: []string{"^api-"}, Excludes: []string{"-sandbox$"}, CreatedAfter: time.Now()}},
+        {name: "stack tags", criteria: StackCriteria{Tags: []nukeconfig.Filter{{Property: "tag:env", Value: "prod"}}}, needs: true},
+        {name: "termination protection", criteria: StackCriteria{TerminationProtected: true}, needs: true},
+        {name: "description regex", criteria: StackCriteria{DescriptionRegexes: []string{"baseline"}}, needs: true},
+        {name: "role regex", criteria: StackCriteria{RoleRegexes: []string{"pipeline"}}, needs: true},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            selector, err := NewStackSelector(test.criteria)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if needs := selector.NeedsDetails(); needs != test.needs {
+                t.Errorf("NeedsDetails() = %v, want %v", needs, test.needs)
+            }
+        })
+    }
+}
+
+func TestParseTime(t *testing.T) {
+    now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+    tests := []struct {
+        value   string
+        time    time.Time
+        invalid bool
+    }{
+        {value: "", time: time.Time{}},
+        {value: "2024-03-01T08:30:00Z", time: time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC)},
+        {value: "2024-03-01", time: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+        {value: " 2024-03-01 ", time: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+        {value: "30d", time: time.Date(2024, 5, 16, 12, 0, 0, 0, time.UTC)},
+        {value: "36h", time: time.Date(2024, 6, 14, 0, 0, 0, 0, time.UTC)},
+        {value: "0d", time: now},
+
+        {value: "-1d", invalid: true},
+        {value: "-2h", invalid: true},
+        {value: "yesterday", invalid: true},
+        {value: "2024-13-01", invalid: true},
+    }
+
+    for _, test := range tests {
+        t.Run(test.value, func(t *testing.T) {
+            parsed, err := ParseTime(test.value, now)
+            if test.invalid {
+                if err == nil {
+                    t.Errorf("ParseTime(%q) = %v, want an error", test.value, parsed)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("ParseTime(%q): %v", test.value, err)
+            }
+            if !parsed.Equal(test.time) {
+                t.Errorf("ParseTime(%q) = %v, want %v", test.value, parsed, test.time)
+            }
+        })
+    }
+}
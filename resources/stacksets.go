@@ -0,0 +1,97 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"go.uber.org/zap"
+)
+
+// A stack deployed into the account by a StackSet
+type StackSetInstance struct {
+    StackSetName string
+    Region       string
+    StackID      string
+}
+
+// Return the instances in the given account of the named StackSets which are administered from the region: self-managed StackSets of
+// the account, then service-managed StackSets when the account is a delegated administrator. StackSets administered from another
+// account, e.g. the management account, are not visible here, and are only found by their stack names when StackSetNameFallback is set
+func GetStackSetInstances(logger *zap.Logger, stackSetNames []string, account string, region string) ([]StackSetInstance, []DiscoveryError) {
+    svc, err := newCFNClient(region)
+    if err != nil {
+        return nil, []DiscoveryError{{Region: region, Err: err}}
+    }
+
+    wanted := make(map[string]bool)
+    for _, name := range stackSetNames {
+        wanted[name] = true
+    }
+
+    instances, err := listStackSetInstances(logger, svc, wanted, account, region, types.CallAsSelf)
+    if err != nil {
+        return instances, []DiscoveryError{{Region: region, Err: err}}
+    }
+    // Most accounts are not a delegated administrator, and are refused, which is not a discovery error
+    delegated, err := listStackSetInstances(logger, svc, wanted, account, region, types.CallAsDelegatedAdmin)
+    if err != nil {
+        logger.Debug(fmt.Sprintf("Unable to list StackSets as delegated administrator in %s: %v", region, err))
+    }
+    return append(instances, delegated...), nil
+}
+
+// Return the instances in the account of the wanted StackSets, listing them with the permissions of callAs
+func listStackSetInstances(logger *zap.Logger, svc *cloudformation.Client, wanted map[string]bool, account string, region string, callAs types.CallAs) ([]StackSetInstance, error) {
+    var instances []StackSetInstance
+    paginator := cloudformation.NewListStackSetsPaginator(svc, &cloudformation.ListStackSetsInput{
+        Status: types.StackSetStatusActive,
+        CallAs: callAs,
+    })
+    for paginator.HasMorePages() {
+        resp, err := paginator.NextPage(context.TODO())
+        if err != nil {
+            return instances, fmt.Errorf("failed to list StackSets as %s, %w", callAs, err)
+        }
+
+        for _, summary := range resp.Summaries {
+            if !wanted[*summary.StackSetName] {
+                continue
+            }
+            logger.Debug(fmt.Sprintf("StackSet %s is administered from %s, listed as %s", *summary.StackSetName, region, callAs))
+
+            // The instances of the StackSet in the account, in every region
+            instancePaginator := cloudformation.NewListStackInstancesPaginator(svc, &cloudformation.ListStackInstancesInput{
+                StackSetName:         summary.StackSetName,
+                StackInstanceAccount: aws.String(account),
+                CallAs:               callAs,
+            })
+            for instancePaginator.HasMorePages() {
+                instanceResp, err := instancePaginator.NextPage(context.TODO())
+                if err != nil {
+                    return instances, fmt.Errorf("failed to list the instances of StackSet %s, %w", *summary.StackSetName, err)
+                }
+                for _, instance := range instanceResp.Summaries {
+                    if instance.StackId == nil {
+                        // The instance has no stack, e.g. it failed to deploy
+                        continue
+                    }
+                    instances = append(instances, StackSetInstance{
+                        StackSetName: *summary.StackSetName,
+                        Region:       *instance.Region,
+                        StackID:      *instance.StackId,
+                    })
+                }
+            }
+        }
+    }
+    return instances, nil
+}
+
+// Return the regex matching exactly the names of the stacks which CloudFormation creates for instances of the StackSet, StackSet-<name>-<uuid>
+func stackSetStackNameRegex(stackSetName string) *regexp.Regexp {
+    return regexp.MustCompile("^StackSet-" + regexp.QuoteMeta(stackSetName) + "-[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$")
+}
@@ -0,0 +1,90 @@
+package resources
+
+import (
+	"awsnukeshield/nukeconfig"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi/types"
+	"go.uber.org/zap"
+)
+
+// A resource found by its tags. CFNType and PhysicalID are empty when the type of the ARN is not recognised
+type TaggedResource struct {
+    Region     string
+    ARN        string
+    CFNType    string
+    PhysicalID string
+}
+
+// Return the resources in the region which carry ALL of the given tag filters, using the Resource Groups Tagging API.
+// Only resources which the tagging API supports are found. The API narrows by tag key, and exact values, and the returned tags are then
+// matched against every filter, so that globs, regexes and contains filters apply too
+func GetResourcesWithAllTags(logger *zap.Logger, tagFilters []nukeconfig.Filter, region string) ([]TaggedResource, []DiscoveryError) {
+    cfg, err := config.LoadDefaultConfig(context.TODO(),
+        config.WithRegion(region),
+    )
+    if err != nil {
+        return nil, []DiscoveryError{{Region: region, Err: fmt.Errorf("unable to load SDK config, %w", err)}}
+    }
+    svc := resourcegroupstaggingapi.NewFromConfig(cfg)
+
+    var apiTagFilters []types.TagFilter
+    for _, filter := range tagFilters {
+        apiTagFilter := types.TagFilter{Key: aws.String(strings.TrimPrefix(filter.Property, "tag:"))}
+        if (filter.Type == "" || filter.Type == nukeconfig.FilterTypeExact) && filter.Invert != "true" {
+            apiTagFilter.Values = []string{filter.Value}
+        }
+        apiTagFilters = append(apiTagFilters, apiTagFilter)
+    }
+
+    var taggedResources []TaggedResource
+    paginator := resourcegroupstaggingapi.NewGetResourcesPaginator(svc, &resourcegroupstaggingapi.GetResourcesInput{TagFilters: apiTagFilters})
+    for paginator.HasMorePages() {
+        page, err := paginator.NextPage(context.TODO())
+        if err != nil {
+            return taggedResources, []DiscoveryError{{Region: region, Err: fmt.Errorf("failed to get tagged resources, %w", err)}}
+        }
+
+        for _, mapping := range page.ResourceTagMappingList {
+            tags := make(map[string]string)
+            for _, tag := range mapping.Tags {
+                tags[*tag.Key] = *tag.Value
+            }
+
+            matched, err := matchAllTags(tagFilters, tags)
+            if err != nil {
+                return taggedResources, []DiscoveryError{{Region: region, Err: err}}
+            }
+            if !matched {
+                logger.Debug(fmt.Sprintf("%s does not match all the tags: %v", *mapping.ResourceARN, tags))
+                continue
+            }
+
+            cfnType, physicalID, _ := ResourceFromARN(*mapping.ResourceARN)
+            taggedResources = append(taggedResources, TaggedResource{
+                Region:     region,
+                ARN:        *mapping.ResourceARN,
+                CFNType:    cfnType,
+                PhysicalID: physicalID,
+            })
+        }
+    }
+
+    return taggedResources, nil
+}
+
+// Whether the tags match every one of the tag filters. A missing tag is compared as an empty value, as aws-nuke does
+func matchAllTags(tagFilters []nukeconfig.Filter, tags map[string]string) (bool, error) {
+    for _, filter := range tagFilters {
+        matched, err := filter.Match(tags[strings.TrimPrefix(filter.Property, "tag:")])
+        if err != nil || !matched {
+            return false, err
+        }
+    }
+    return true, nil
+}
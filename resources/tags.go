@@ -1,78 +1,192 @@
 package resources
 
 import (
-	"awsnukeshield/helpers"
+	"awsnukeshield/nukeconfig"
 	"fmt"
-	"os/exec"
+	"regexp"
 	"strings"
 
 	"go.uber.org/zap"
 )
 
-func GenerateTagsConfigSection(logger *zap.Logger, lines []string, tags []string) []string{
-	var filterContents []string
-    var indexToInsert int
+/* A flag type holding a list of tag selectors, split on the commas which are not inside a quoted key or a regex value,
+   so that -tags 'env:/^(dev|prod){1,2}$/,team' gives two selectors. A regex value ends at the first / followed by a comma */
+type TagSelectorListFlag []string
 
-	// Get the aws-nuke resource types
-    awsNukeResourceTypesTemp, err := exec.Command("bash", "-c", "aws-nuke resource-types").Output()
+// Interface method for the custom TagSelectorListFlag
+func (tlf *TagSelectorListFlag) String() string {
+    return strings.Join(*tlf, ", ")
+}
+
+// Interface method for the custom TagSelectorListFlag
+func (tlf *TagSelectorListFlag) Set(value string) error {
+    var selector string
+    for i, element := range strings.Split(value, ",") {
+        if i == 0 {
+            selector = element
+            continue
+        }
+        if unclosedTagSelector(selector) {
+            selector += "," + element
+            continue
+        }
+        *tlf = append(*tlf, selector)
+        selector = element
+    }
+    *tlf = append(*tlf, selector)
+    return nil
+}
+/**/
+
+// Whether a selector was cut at a comma inside its quoted key or its regex value
+func unclosedTagSelector(selector string) bool {
+    selector = strings.TrimSpace(selector)
+    if strings.HasPrefix(selector, `"`) && !strings.Contains(selector[1:], `"`) {
+        return true
+    }
+    _, value, hasValue, err := splitTagSelector(selector)
+    if err != nil || !hasValue {
+        return false
+    }
+    value = strings.TrimPrefix(value, "(?i)")
+    return strings.HasPrefix(value, "/") && (len(value) < 2 || !strings.HasSuffix(value, "/"))
+}
+
+// Parse the -tags selectors into the aws-nuke filters matching them. Every selector is checked, so that nothing is generated from invalid ones
+func ParseTagSelectors(selectors []string) ([]nukeconfig.Filter, error) {
+    var tagFilters []nukeconfig.Filter
+    for _, selector := range selectors {
+        if strings.TrimSpace(selector) == "" {
+            continue
+        }
+        filter, err := ParseTagSelector(selector)
+        if err != nil {
+            return nil, fmt.Errorf("invalid tag selector %q: %w", selector, err)
+        }
+        tagFilters = append(tagFilters, filter)
+    }
+    return tagFilters, nil
+}
+
+// Parse a tag selector into the aws-nuke filter matching it. The forms are:
+//   key           the tag is present with a non-empty value (regex)
+//   key:value     the value is exactly value, which may itself contain colons (exact)
+//   key:va*e      the value matches the glob, when value contains * or ? (glob)
+//   key:/re/      the value matches the regular expression (regex)
+//   key:~part     the value contains part (contains)
+// Prefixing the value with (?i) makes any form case-insensitive, which aws-nuke only supports as a regex. A key containing a colon is
+// quoted, as in "aws:cloudformation:stack-name":my-stack, and a value starting with a backslash is taken literally, as in key:\~part
+func ParseTagSelector(selector string) (nukeconfig.Filter, error) {
+    key, value, hasValue, err := splitTagSelector(strings.TrimSpace(selector))
     if err != nil {
-        panic(err)
-    }
-
-	resourceTypesString := string(awsNukeResourceTypesTemp)
-    awsNukeResourceTypes := strings.Split(resourceTypesString, "\n")
-
-    index_of_filter_block := helpers.FindItem(lines, "filters:")
-    if index_of_filter_block != -1 {
-        // The config file already contains a filter block, so we must check it for each resource type and append the provided tags to each type if it already exists, else create a new type.
-        for _, resourceType := range awsNukeResourceTypes {
-            if len(resourceType) != 0 {
-                    filterContents = []string{}
-
-                    // Check if resource type already exists
-                    index_of_resource_block := helpers.FindItem(lines, fmt.Sprintf("%s:", resourceType))
-                    if index_of_resource_block != -1 {
-                        indexToInsert = index_of_resource_block + 1
-                    } else {
-                        indexToInsert = index_of_filter_block + 1
-                        filterContents = append(filterContents, fmt.Sprintf("      %s:", resourceType))
-                    }
-                    
-                    // Add the tag filters to the temporary variable
-                    for _, tag := range tags{
-                        tagSplit := strings.Split(tag, ":")
-                        filterContents = append(filterContents, fmt.Sprintf("        - property: tag:%s", tagSplit[0]))
-                        filterContents = append(filterContents, fmt.Sprintf("          value: %s", tagSplit[1]))
-                    }
-
-                    // Insert the tag filters at the appropriate place in the file contents
-                    lines = append(lines[:indexToInsert], append(filterContents, lines[indexToInsert:]...)...)
-            }
+        return nukeconfig.Filter{}, err
+    }
+    property := fmt.Sprintf("tag:%s", key)
+
+    if !hasValue {
+        return nukeconfig.Filter{Property: property, Type: nukeconfig.FilterTypeRegex, Value: ".+"}, nil
+    }
+
+    caseInsensitive := strings.HasPrefix(value, "(?i)")
+    value = strings.TrimPrefix(value, "(?i)")
+
+    filterType := nukeconfig.FilterTypeExact
+    switch {
+    case strings.HasPrefix(value, `\`):
+        value = value[1:]
+    case strings.HasPrefix(value, "/"):
+        if len(value) < 2 || !strings.HasSuffix(value, "/") {
+            return nukeconfig.Filter{}, fmt.Errorf("a regex value must be enclosed in slashes, as in key:/re/")
+        }
+        filterType = nukeconfig.FilterTypeRegex
+        value = value[1 : len(value)-1]
+    case strings.HasPrefix(value, "~"):
+        filterType = nukeconfig.FilterTypeContains
+        value = value[1:]
+    case strings.ContainsAny(value, "*?"):
+        filterType = nukeconfig.FilterTypeGlob
+    }
+    if value == "" {
+        return nukeconfig.Filter{}, fmt.Errorf("the value is empty. Use the key alone to match any value")
+    }
+
+    if caseInsensitive {
+        value = caseInsensitiveRegex(filterType, value)
+        filterType = nukeconfig.FilterTypeRegex
+    }
+    if filterType == nukeconfig.FilterTypeRegex {
+        if _, err := regexp.Compile(value); err != nil {
+            return nukeconfig.Filter{}, err
         }
+    }
+    if filterType == nukeconfig.FilterTypeExact {
+        // Exact is aws-nuke's default, so it is left out of the config
+        filterType = ""
+    }
 
+    return nukeconfig.Filter{Property: property, Type: filterType, Value: value}, nil
+}
+
+// Split a selector into its key and value, unquoting the key
+func splitTagSelector(selector string) (key string, value string, hasValue bool, err error) {
+    if strings.HasPrefix(selector, `"`) {
+        end := strings.Index(selector[1:], `"`)
+        if end == -1 {
+            return "", "", false, fmt.Errorf("the quoted key is not closed")
+        }
+        key = selector[1 : end+1]
+        rest := selector[end+2:]
+        if rest != "" && !strings.HasPrefix(rest, ":") {
+            return "", "", false, fmt.Errorf("a quoted key must be followed by :value or nothing")
+        }
+        value, hasValue = strings.CutPrefix(rest, ":")
     } else {
-        // The config file contains no filter block, so we create it and populate it with a block for every aws-nuke resource-type, where each block contains the provided tags
-
-        for _, resourceType := range awsNukeResourceTypes {
-            if len(resourceType) != 0 {
-                    filterContents = append(filterContents, fmt.Sprintf("      %s:", resourceType))
-                    for _, tag := range tags{
-                        tagSplit := strings.Split(tag, ":")
-                        filterContents = append(filterContents, fmt.Sprintf("        - property: tag:%s", tagSplit[0]))
-                        filterContents = append(filterContents, fmt.Sprintf("          value: %s", tagSplit[1]))
-                }
-                
-            }
+        key, value, hasValue = strings.Cut(selector, ":")
+    }
+
+    if key == "" {
+        return "", "", false, fmt.Errorf("the key is empty")
+    }
+    return key, value, hasValue, nil
+}
+
+// Return the regex matching the value of the given filter type case-insensitively
+func caseInsensitiveRegex(filterType string, value string) string {
+    switch filterType {
+    case nukeconfig.FilterTypeRegex:
+        return "(?i)" + value
+    case nukeconfig.FilterTypeContains:
+        return "(?i)" + regexp.QuoteMeta(value)
+    case nukeconfig.FilterTypeGlob:
+        return "(?i)^" + nukeconfig.GlobPattern(value) + "$"
+    default:
+        return "(?i)^" + regexp.QuoteMeta(value) + "$"
+    }
+}
+
+// Add the given tag filters under the filters of the given account. With global set, the filters are written once under
+// the __global__ key, which aws-nuke applies to every resource type. Otherwise they are repeated for every aws-nuke resource type
+func GenerateTagsConfigSection(logger *zap.Logger, doc *nukeconfig.Document, account string, awsNukeResourceTypes []string, tagFilters []nukeconfig.Filter, global bool) error {
+    if len(tagFilters) == 0 {
+        return nil
+    }
+
+    if global {
+        if err := doc.AddFilters(account, nukeconfig.GlobalFilterKey, tagFilters...); err != nil {
+            return err
+        }
+        logger.Debug(fmt.Sprintf("Added global tag filters: %v", tagFilters))
+        return nil
+    }
+
+    // Add the tag filters to every resource type, creating the resource type block where it does not yet exist
+    for _, resourceType := range awsNukeResourceTypes {
+        if err := doc.AddFilters(account, resourceType, tagFilters...); err != nil {
+            return err
         }
-    
-        // Create a filters section at the bottom of the file, and add the resource types with tag filters underneath
-        lines = append(lines, "    filters:")
-        lines = append(lines, filterContents...)
     }
 
-    
-	
-    logger.Debug(fmt.Sprintf("Added tag blocks for every resource to the filters section: %v", lines))
+    logger.Debug(fmt.Sprintf("Added tag filters for every resource type: %v", tagFilters))
 
-    return lines
-}
\ No newline at end of file
+    return nil
+}
@@ -0,0 +1,106 @@
+package resources
+
+import (
+	"awsnukeshield/nukeconfig"
+	"reflect"
+	"testing"
+)
+
+func TestParseTagSelector(t *testing.T) {
+    tests := []struct {
+        selector string
+        filter   nukeconfig.Filter
+        invalid  bool
+    }{
+        {selector: "terraform", filter: nukeconfig.Filter{Property: "tag:terraform", Type: "regex", Value: ".+"}},
+        {selector: "env:prod", filter: nukeconfig.Filter{Property: "tag:env", Value: "prod"}},
+        {selector: " env:prod ", filter: nukeconfig.Filter{Property: "tag:env", Value: "prod"}},
+        {selector: "owner:arn:aws:iam::123456789012:role/admin", filter: nukeconfig.Filter{Property: "tag:owner", Value: "arn:aws:iam::123456789012:role/admin"}},
+        {selector: "env:prod-*", filter: nukeconfig.Filter{Property: "tag:env", Type: "glob", Value: "prod-*"}},
+        {selector: "env:prod-?", filter: nukeconfig.Filter{Property: "tag:env", Type: "glob", Value: "prod-?"}},
+        {selector: "env:/^prod-[0-9]+$/", filter: nukeconfig.Filter{Property: "tag:env", Type: "regex", Value: "^prod-[0-9]+$"}},
+        {selector: "env:/a{1,2}/", filter: nukeconfig.Filter{Property: "tag:env", Type: "regex", Value: "a{1,2}"}},
+        {selector: "env:~prod", filter: nukeconfig.Filter{Property: "tag:env", Type: "contains", Value: "prod"}},
+        {selector: "env:(?i)prod", filter: nukeconfig.Filter{Property: "tag:env", Type: "regex", Value: "(?i)^prod$"}},
+        {selector: "env:(?i)prod-*", filter: nukeconfig.Filter{Property: "tag:env", Type: "regex", Value: "(?i)^prod-.*$"}},
+        {selector: "env:(?i)/^prod/", filter: nukeconfig.Filter{Property: "tag:env", Type: "regex", Value: "(?i)^prod"}},
+        {selector: "env:(?i)~pr.d", filter: nukeconfig.Filter{Property: "tag:env", Type: "regex", Value: `(?i)pr\.d`}},
+        {selector: `"aws:cloudformation:stack-name":my-stack`, filter: nukeconfig.Filter{Property: "tag:aws:cloudformation:stack-name", Value: "my-stack"}},
+        {selector: `"aws:cloudformation:stack-name"`, filter: nukeconfig.Filter{Property: "tag:aws:cloudformation:stack-name", Type: "regex", Value: ".+"}},
+        {selector: `key:\~part`, filter: nukeconfig.Filter{Property: "tag:key", Value: "~part"}},
+        {selector: `key:\/path/`, filter: nukeconfig.Filter{Property: "tag:key", Value: "/path/"}},
+
+        {selector: ":prod", invalid: true},
+        {selector: "env:", invalid: true},
+        {selector: "env:~", invalid: true},
+        {selector: "env://", invalid: true},
+        {selector: "env:/", invalid: true},
+        {selector: "env:/^prod", invalid: true},
+        {selector: "env:/(prod/", invalid: true},
+        {selector: "env:(?i)", invalid: true},
+        {selector: `"aws:cloudformation:stack-name`, invalid: true},
+        {selector: `"aws:cloudformation"stack-name`, invalid: true},
+        {selector: `"":prod`, invalid: true},
+    }
+
+    for _, test := range tests {
+        t.Run(test.selector, func(t *testing.T) {
+            filter, err := ParseTagSelector(test.selector)
+            if test.invalid {
+                if err == nil {
+                    t.Errorf("ParseTagSelector(%q) = %+v, want an error", test.selector, filter)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("ParseTagSelector(%q): %v", test.selector, err)
+            }
+            if !reflect.DeepEqual(filter, test.filter) {
+                t.Errorf("ParseTagSelector(%q) = %+v, want %+v", test.selector, filter, test.filter)
+            }
+        })
+    }
+}
+
+func TestParseTagSelectorsRejectsAnyInvalid(t *testing.T) {
+    if _, err := ParseTagSelectors([]string{"env:prod", "env:/(prod/"}); err == nil {
+        t.Error("ParseTagSelectors accepted an invalid selector")
+    }
+    filters, err := ParseTagSelectors([]string{"env:prod", " ", ""})
+    if err != nil || len(filters) != 1 {
+        t.Errorf("ParseTagSelectors = %+v, %v, want one filter", filters, err)
+    }
+}
+
+func TestTagSelectorListFlag(t *testing.T) {
+    tests := []struct {
+        values    []string
+        selectors TagSelectorListFlag
+    }{
+        {[]string{"env:prod,team:core"}, TagSelectorListFlag{"env:prod", "team:core"}},
+        {[]string{"env:prod", "team"}, TagSelectorListFlag{"env:prod", "team"}},
+        {[]string{"k:/a{1,2}/"}, TagSelectorListFlag{"k:/a{1,2}/"}},
+        {[]string{"env:/^(dev|prod){1,2}$/,team"}, TagSelectorListFlag{"env:/^(dev|prod){1,2}$/", "team"}},
+        {[]string{"env:(?i)/a,b,c/,team:core"}, TagSelectorListFlag{"env:(?i)/a,b,c/", "team:core"}},
+        // The regex ends at the first / followed by a comma
+        {[]string{"k:/a/,b/"}, TagSelectorListFlag{"k:/a/", "b/"}},
+        {[]string{`"a,b:c":v,team`}, TagSelectorListFlag{`"a,b:c":v`, "team"}},
+        // Globs and exact values are still split
+        {[]string{"k:a*,b"}, TagSelectorListFlag{"k:a*", "b"}},
+        // An unclosed regex keeps the rest of the value, and is rejected by ParseTagSelector
+        {[]string{"k:/a,b"}, TagSelectorListFlag{"k:/a,b"}},
+        {[]string{"env:prod,"}, TagSelectorListFlag{"env:prod", ""}},
+    }
+
+    for _, test := range tests {
+        var selectors TagSelectorListFlag
+        for _, value := range test.values {
+            if err := selectors.Set(value); err != nil {
+                t.Fatal(err)
+            }
+        }
+        if !reflect.DeepEqual(selectors, test.selectors) {
+            t.Errorf("Set(%q) = %q, want %q", test.values, selectors, test.selectors)
+        }
+    }
+}
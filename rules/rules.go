@@ -0,0 +1,98 @@
+package rules
+
+import (
+	"awsnukeshield/expand"
+	"awsnukeshield/nukeconfig"
+	"awsnukeshield/translate"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The default location of the rules file
+const DefaultRulesFile = "shield-rules.yml"
+
+// The user-editable rules which shape the generated config, beyond what is discovered
+type Rules struct {
+    // Filters added to the config on every run, by aws-nuke type. For resources which are not captured by stack regexes or tags
+    Filters    map[string][]nukeconfig.Filter `yaml:"filters"`
+    // How the CFN physical IDs of resources of an aws-nuke type are turned into filters, overriding the built-in rules
+    Identities map[string]translate.Rule      `yaml:"identities"`
+    // Related aws-nuke types to preserve alongside each preserved resource of the key type
+    Companions map[string][]expand.Rule        `yaml:"companions"`
+}
+
+// Load and merge the rules files in order. Where files set the identity of the same type, the later file wins; filters and companions accumulate
+func Load(paths ...string) (*Rules, error) {
+    merged := &Rules{}
+    for _, path := range paths {
+        data, err := os.ReadFile(path)
+        if err != nil {
+            return nil, err
+        }
+        rules, err := Parse(data)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", path, err)
+        }
+        merged.Merge(rules)
+    }
+    return merged, nil
+}
+
+// Parse and validate the contents of a rules file
+func Parse(data []byte) (*Rules, error) {
+    var rules Rules
+    if err := yaml.Unmarshal(data, &rules); err != nil {
+        return nil, err
+    }
+    if err := rules.Validate(); err != nil {
+        return nil, err
+    }
+    return &rules, nil
+}
+
+// Add other to these rules
+func (r *Rules) Merge(other *Rules) {
+    if r.Filters == nil {
+        r.Filters = make(map[string][]nukeconfig.Filter)
+    }
+    if r.Identities == nil {
+        r.Identities = make(map[string]translate.Rule)
+    }
+    if r.Companions == nil {
+        r.Companions = make(map[string][]expand.Rule)
+    }
+    for awsNukeType, filters := range other.Filters {
+        r.Filters[awsNukeType] = append(r.Filters[awsNukeType], filters...)
+    }
+    for awsNukeType, identity := range other.Identities {
+        r.Identities[awsNukeType] = identity
+    }
+    for awsNukeType, companions := range other.Companions {
+        r.Companions[awsNukeType] = append(r.Companions[awsNukeType], companions...)
+    }
+}
+
+func (r *Rules) Validate() error {
+    for awsNukeType, identity := range r.Identities {
+        if err := identity.Validate(); err != nil {
+            return fmt.Errorf("identity of %s: %w", awsNukeType, err)
+        }
+    }
+    for awsNukeType, companions := range r.Companions {
+        for _, companion := range companions {
+            if err := companion.Validate(); err != nil {
+                return fmt.Errorf("companion of %s: %w", awsNukeType, err)
+            }
+        }
+    }
+    for awsNukeType, filters := range r.Filters {
+        for _, filter := range filters {
+            if filter.Value == "" {
+                return fmt.Errorf("filter of %s: value is required", awsNukeType)
+            }
+        }
+    }
+    return nil
+}
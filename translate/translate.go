@@ -0,0 +1,198 @@
+package translate
+
+import (
+	"awsnukeshield/nukeconfig"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Turns the physical ID which CFN reports for a resource into the aws-nuke filter which matches that resource
+type Translator interface {
+    Translate(physicalID string) (nukeconfig.Filter, error)
+}
+
+// Ways of extracting the identifier aws-nuke compares against from a CFN physical ID
+const (
+    // The physical ID unchanged
+    ExtractID          = "id"
+    // The part after the last "/" of an ARN or URL, e.g. the queue name of an SQS queue URL
+    ExtractLastSegment = "last-segment"
+    // The name in an ARN whose resource is <type>/<name>/<id>, e.g. an ELBv2 target group
+    ExtractARNName     = "arn-name"
+    // The first part of a compound "a|b" physical ID
+    ExtractFirstPart   = "first-part"
+    // The last part of a compound "a|b" physical ID
+    ExtractLastPart    = "last-part"
+)
+
+var extractors = map[string]func(string) string{
+    ExtractID: func(physicalID string) string {
+        return physicalID
+    },
+    ExtractLastSegment: func(physicalID string) string {
+        parts := strings.Split(strings.TrimSuffix(physicalID, "/"), "/")
+        return parts[len(parts)-1]
+    },
+    ExtractARNName: func(physicalID string) string {
+        parts := strings.Split(physicalID, "/")
+        if len(parts) < 3 {
+            return parts[len(parts)-1]
+        }
+        return parts[len(parts)-2]
+    },
+    ExtractFirstPart: func(physicalID string) string {
+        return strings.Split(physicalID, "|")[0]
+    },
+    ExtractLastPart: func(physicalID string) string {
+        parts := strings.Split(physicalID, "|")
+        return parts[len(parts)-1]
+    },
+}
+
+// A declarative translator: the extracted identifier, optionally placed into Format (which must then contain a single %s),
+// is compared by aws-nuke against Property using the filter Type. An empty Property compares against the resource ID aws-nuke prints
+type Rule struct {
+    Property string `yaml:"property,omitempty"`
+    Type     string `yaml:"type,omitempty"`
+    Extract  string `yaml:"extract,omitempty"`
+    Format   string `yaml:"format,omitempty"`
+}
+
+func (r Rule) Validate() error {
+    switch r.Type {
+    case "", nukeconfig.FilterTypeExact, nukeconfig.FilterTypeGlob, nukeconfig.FilterTypeRegex, nukeconfig.FilterTypeContains:
+    default:
+        return fmt.Errorf("unknown filter type %q", r.Type)
+    }
+    if _, ok := extractors[r.extract()]; !ok {
+        return fmt.Errorf("unknown extract %q", r.Extract)
+    }
+    if r.Format != "" && strings.Count(r.Format, "%s") != 1 {
+        return fmt.Errorf("format %q must contain %%s exactly once", r.Format)
+    }
+    return nil
+}
+
+func (r Rule) Translate(physicalID string) (nukeconfig.Filter, error) {
+    value := extractors[r.extract()](physicalID)
+    if value == "" {
+        return nukeconfig.Filter{}, fmt.Errorf("no identifier could be extracted from %q", physicalID)
+    }
+    if r.Format != "" {
+        value = fmt.Sprintf(r.Format, value)
+    }
+    return nukeconfig.Filter{Property: r.Property, Type: r.Type, Value: value}, nil
+}
+
+// Describe the rule for display, e.g. in the mappings command
+func (r Rule) String() string {
+    property := r.Property
+    if property == "" {
+        property = "(resource ID)"
+    }
+    filterType := r.Type
+    if filterType == "" {
+        filterType = nukeconfig.FilterTypeExact
+    }
+    description := fmt.Sprintf("%s %s %s", property, filterType, r.extract())
+    if r.Format != "" {
+        description += fmt.Sprintf(" as %q", r.Format)
+    }
+    return description
+}
+
+func (r Rule) extract() string {
+    if r.Extract == "" {
+        return ExtractID
+    }
+    return r.Extract
+}
+
+// Translators shipped with Shield, for aws-nuke types whose resources are not identified by the CFN physical ID as-is
+var builtin = map[string]Rule{
+    // CFN reports the bucket name, aws-nuke prints s3://<name> and exposes the name as a property
+    "S3Bucket":             {Property: "Name"},
+    // CFN reports the topic ARN, which aws-nuke exposes as a property
+    "SNSTopic":             {Property: "TopicARN"},
+    // The ID aws-nuke prints for a subscription also includes the owner
+    "SNSSubscription":      {Type: nukeconfig.FilterTypeContains},
+    // CFN reports the queue URL
+    "SQSQueue":             {Property: "QueueURL"},
+    // CFN reports the policy ARN
+    "IAMPolicy":            {Property: "ARN"},
+    // Nested stacks are preserved by name
+    "CloudFormationStack":  {Property: "Name"},
+    // Rules on a custom event bus are reported as <bus>|<rule>. aws-nuke prints "Rule: <rule>" and exposes no properties
+    "CloudWatchEventsRule": {Extract: ExtractLastPart, Format: "Rule: %s"},
+    // Some API Gateway physical IDs are compound <name>|<id>
+    "APIGatewayRestAPI":    {Extract: ExtractLastPart},
+    // CFN reports the cluster name, aws-nuke identifies clusters by ARN
+    "ECSCluster":           {Type: nukeconfig.FilterTypeGlob, Format: "arn:aws*:ecs:*:*:cluster/%s"},
+    // CFN reports the ARN, aws-nuke identifies load balancers and target groups by name
+    "ELBv2":                {Extract: ExtractARNName},
+    "ELBv2TargetGroup":     {Extract: ExtractARNName},
+    // CFN reports the bare zone ID, aws-nuke prints /hostedzone/<id>
+    "Route53HostedZone":    {Type: nukeconfig.FilterTypeContains},
+}
+
+// Holds the translator for each aws-nuke type. Types without one are matched exactly against the physical ID
+type Registry struct {
+    translators map[string]Translator
+    overridden  map[string]bool
+}
+
+// Create a registry of the built-in translators, with the user's rules overriding them
+func NewRegistry(overrides map[string]Rule) (*Registry, error) {
+    registry := &Registry{translators: make(map[string]Translator), overridden: make(map[string]bool)}
+    for awsNukeType, rule := range builtin {
+        registry.Register(awsNukeType, rule)
+    }
+    for awsNukeType, rule := range overrides {
+        if err := rule.Validate(); err != nil {
+            return nil, fmt.Errorf("identity rule for %s: %w", awsNukeType, err)
+        }
+        registry.Register(awsNukeType, rule)
+        registry.overridden[awsNukeType] = true
+    }
+    return registry, nil
+}
+
+// Use translator for resources of awsNukeType
+func (r *Registry) Register(awsNukeType string, translator Translator) {
+    r.translators[awsNukeType] = translator
+}
+
+// Return the filter preserving the resource of awsNukeType with the given CFN physical ID
+func (r *Registry) Filter(awsNukeType string, physicalID string) (nukeconfig.Filter, error) {
+    translator, ok := r.translators[awsNukeType]
+    if !ok {
+        return nukeconfig.Filter{Value: physicalID}, nil
+    }
+    filter, err := translator.Translate(physicalID)
+    if err != nil {
+        return nukeconfig.Filter{}, fmt.Errorf("%s %s: %w", awsNukeType, physicalID, err)
+    }
+    return filter, nil
+}
+
+// Whether the translator for awsNukeType was provided by the user
+func (r *Registry) Overridden(awsNukeType string) bool {
+    return r.overridden[awsNukeType]
+}
+
+// Return the translator for awsNukeType, if it has one
+func (r *Registry) Translator(awsNukeType string) (Translator, bool) {
+    translator, ok := r.translators[awsNukeType]
+    return translator, ok
+}
+
+// The aws-nuke types which have a translator, sorted
+func (r *Registry) Types() []string {
+    var awsNukeTypes []string
+    for awsNukeType := range r.translators {
+        awsNukeTypes = append(awsNukeTypes, awsNukeType)
+    }
+    sort.Strings(awsNukeTypes)
+    return awsNukeTypes
+}
@@ -0,0 +1,87 @@
+package translate
+
+import (
+	"testing"
+)
+
+// What aws-nuke prints for a resource: its ID, and the properties filters can compare against
+type nukeResource struct {
+    id         string
+    properties map[string]string
+}
+
+func (r nukeResource) value(property string) string {
+    if property == "" {
+        return r.id
+    }
+    return r.properties[property]
+}
+
+// Every built-in rule, checked against the ID and properties aws-nuke reports for a resource with the CFN physical ID
+func TestBuiltinRulesMatchAwsNukeIdentities(t *testing.T) {
+    tests := []struct {
+        awsNukeType string
+        physicalID  string
+        resource    nukeResource
+    }{
+        {"S3Bucket", "app-artifacts-1q2w3e", nukeResource{"s3://app-artifacts-1q2w3e", map[string]string{"Name": "app-artifacts-1q2w3e", "CreationDate": "2023-09-14T12:01:44Z"}}},
+        {"SNSTopic", "arn:aws:sns:eu-west-1:123456789012:alerts", nukeResource{"arn:aws:sns:eu-west-1:123456789012:alerts", map[string]string{"TopicARN": "arn:aws:sns:eu-west-1:123456789012:alerts"}}},
+        {"SNSSubscription", "arn:aws:sns:eu-west-1:123456789012:alerts:0b1c2d3e", nukeResource{"Owner: 123456789012 ARN: arn:aws:sns:eu-west-1:123456789012:alerts:0b1c2d3e", nil}},
+        {"SQSQueue", "https://sqs.eu-west-1.amazonaws.com/123456789012/jobs", nukeResource{"https://sqs.eu-west-1.amazonaws.com/123456789012/jobs", map[string]string{"QueueURL": "https://sqs.eu-west-1.amazonaws.com/123456789012/jobs"}}},
+        {"IAMPolicy", "arn:aws:iam::123456789012:policy/app-policy", nukeResource{"arn:aws:iam::123456789012:policy/app-policy", map[string]string{"ARN": "arn:aws:iam::123456789012:policy/app-policy", "Name": "app-policy"}}},
+        {"CloudFormationStack", "app-network", nukeResource{"app-network", map[string]string{"Name": "app-network"}}},
+        {"CloudWatchEventsRule", "app-Schedule-7HJK2L3M", nukeResource{"Rule: app-Schedule-7HJK2L3M", nil}},
+        {"CloudWatchEventsRule", "app-bus|app-Schedule-7HJK2L3M", nukeResource{"Rule: app-Schedule-7HJK2L3M", nil}},
+        {"APIGatewayRestAPI", "a1b2c3d4e5", nukeResource{"a1b2c3d4e5", nil}},
+        {"ECSCluster", "app-cluster", nukeResource{"arn:aws:ecs:eu-west-1:123456789012:cluster/app-cluster", nil}},
+        {"ELBv2", "arn:aws:elasticloadbalancing:eu-west-1:123456789012:loadbalancer/app/app-alb/50dc6c495c0c9188", nukeResource{"app-alb", nil}},
+        {"ELBv2TargetGroup", "arn:aws:elasticloadbalancing:eu-west-1:123456789012:targetgroup/app-tg/73e2d6bc24d8a067", nukeResource{"app-tg", nil}},
+        {"Route53HostedZone", "Z1D633PJN98FT9", nukeResource{"/hostedzone/Z1D633PJN98FT9", nil}},
+    }
+
+    tested := make(map[string]bool)
+    registry, err := NewRegistry(nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, test := range tests {
+        tested[test.awsNukeType] = true
+        t.Run(test.awsNukeType+" "+test.physicalID, func(t *testing.T) {
+            filter, err := registry.Filter(test.awsNukeType, test.physicalID)
+            if err != nil {
+                t.Fatal(err)
+            }
+            matched, err := filter.Match(test.resource.value(filter.Property))
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !matched {
+                t.Errorf("filter %+v does not match %s %+v, so the resource is not preserved", filter, test.awsNukeType, test.resource)
+            }
+        })
+    }
+    for awsNukeType := range builtin {
+        if !tested[awsNukeType] {
+            t.Errorf("the built-in rule for %s is not checked", awsNukeType)
+        }
+    }
+}
+
+func TestRuleValidate(t *testing.T) {
+    tests := []struct {
+        rule  Rule
+        valid bool
+    }{
+        {Rule{}, true},
+        {Rule{Type: "glob", Extract: ExtractLastSegment, Format: "prefix-%s"}, true},
+        {Rule{Type: "fuzzy"}, false},
+        {Rule{Extract: "middle"}, false},
+        {Rule{Format: "no placeholder"}, false},
+        {Rule{Format: "%s-%s"}, false},
+    }
+    for _, test := range tests {
+        if err := test.rule.Validate(); (err == nil) != test.valid {
+            t.Errorf("%+v Validate() = %v, want valid %v", test.rule, err, test.valid)
+        }
+    }
+}